@@ -0,0 +1,261 @@
+package toml
+
+import (
+	"bytes"
+	"errors"
+	"github.com/pelletier/go-toml"
+	"io"
+	"io/fs"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// A Decoder reads TOML documents into structs with the settings given by its options.
+type Decoder struct {
+	overlays        []string
+	parents         map[string]string
+	tagName         string
+	naming          func(string) string
+	hooks           []Hook
+	sources         []Source
+	allErrors       bool
+	strict          bool
+	environments    []string
+	deepMerge       bool
+	durationUnit    time.Duration
+	envOverrides    bool
+	envPrefix       string
+	environmentFrom bool
+	environmentVars []string
+	metadata        *Metadata
+	flags           map[string]string
+}
+
+// An Option configures a Decoder.
+type Option func(*Decoder)
+
+// A Hook converts a raw TOML value before it is set to a value of type t.
+type Hook func(t reflect.Type, v interface{}) (interface{}, error)
+
+// A Source supplies the TOML document read by a Decoder.
+type Source struct {
+	name string
+	load func() (*toml.TomlTree, error)
+	// optional sources are skipped if they do not exist.
+	optional bool
+	// perEnv makes the source of each environment loaded, see EnvFile.
+	perEnv func(env string) Source
+}
+
+// NewDecoder returns a Decoder configured by opts.
+func NewDecoder(opts ...Option) *Decoder {
+	d := &Decoder{
+		tagName:      "toml",
+		naming:       toSnake,
+		durationUnit: time.Nanosecond,
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// WithEnvironment sets the environment whose sections overwrite the default settings.
+func WithEnvironment(env string) Option {
+	return WithOverlays(env)
+}
+
+// WithOverlays sets the environment sections that overwrite the default settings,
+// ordered from the least specific to the most specific, for example
+// "production", "production.eu-west", "tenant-acme".
+//
+// Each value is looked up in the most specific overlay first, then in the
+// environments it inherits from, then in the less specific overlays and
+// finally in the default settings.
+func WithOverlays(overlays ...string) Option {
+	return func(d *Decoder) {
+		d.overlays = overlays
+	}
+}
+
+// WithParent makes env inherit the settings of parent.
+// It takes priority over an "extends" key in the environment section.
+func WithParent(env, parent string) Option {
+	return func(d *Decoder) {
+		if d.parents == nil {
+			d.parents = map[string]string{}
+		}
+		d.parents[env] = parent
+	}
+}
+
+// WithTagName sets the struct tag used for key names. The default is "toml".
+func WithTagName(name string) Option {
+	return func(d *Decoder) {
+		d.tagName = name
+	}
+}
+
+// WithNaming sets the function that converts field names without a tag to keys.
+// The default converts CamelCase to snake_case.
+func WithNaming(naming func(string) string) Option {
+	return func(d *Decoder) {
+		d.naming = naming
+	}
+}
+
+// WithHook adds a hook that is called for every raw TOML value in the order added.
+func WithHook(hook Hook) Option {
+	return func(d *Decoder) {
+		d.hooks = append(d.hooks, hook)
+	}
+}
+
+// WithAllErrors makes Decode set every field it can and return all errors
+// together as LoadErrors instead of stopping at the first one.
+func WithAllErrors() Option {
+	return func(d *Decoder) {
+		d.allErrors = true
+	}
+}
+
+// WithStrict makes Decode fail for keys that no field reads and for sections
+// of environments not declared by WithEnvironments.
+func WithStrict() Option {
+	return func(d *Decoder) {
+		d.strict = true
+	}
+}
+
+// WithEnvironments declares the names of the environments used in the TOML document.
+// Once environments are declared, by this option or by an [_environments] table,
+// Decode fails for an undeclared environment and for sections of undeclared environments.
+func WithEnvironments(envs ...string) Option {
+	return func(d *Decoder) {
+		d.environments = append(d.environments, envs...)
+	}
+}
+
+// WithDeepMerge makes environment sections overwrite tables key by key, so an
+// environment section only needs the keys it changes, at any depth.
+// Without it, a table defined in an environment section replaces the whole table.
+func WithDeepMerge() Option {
+	return func(d *Decoder) {
+		d.deepMerge = true
+	}
+}
+
+// WithDurationUnit sets the unit of integers decoded into time.Duration.
+// The default is time.Nanosecond. Strings are parsed by time.ParseDuration.
+func WithDurationUnit(unit time.Duration) Option {
+	return func(d *Decoder) {
+		d.durationUnit = unit
+	}
+}
+
+// WithEnvOverrides makes environment variables override the settings of every environment.
+// The variable of a key is its key path in upper case joined by underscores after prefix,
+// for example APP_DATABASE_CONNECTION_MAX for database.connection_max with the prefix "APP".
+// An `env:"DB_URL"` tag sets the variable of a field regardless of prefix.
+// Values are converted like default values. A pointer to a struct is allocated when
+// a variable sets one of its fields, even if its table is not defined.
+func WithEnvOverrides(prefix string) Option {
+	return func(d *Decoder) {
+		d.envOverrides = true
+		d.envPrefix = prefix
+	}
+}
+
+// WithEnvironmentFrom resolves the environment, when it is not given by WithEnvironment or
+// WithOverlays, from the first of the variables vars that is set, for example "APP_ENV" and
+// "GO_ENV", or else from a top-level "environment" key of the TOML document.
+func WithEnvironmentFrom(vars ...string) Option {
+	return func(d *Decoder) {
+		d.environmentFrom = true
+		d.environmentVars = vars
+	}
+}
+
+// WithMetadata makes Decode store what it resolved while loading into md.
+func WithMetadata(md *Metadata) Option {
+	return func(d *Decoder) {
+		d.metadata = md
+	}
+}
+
+// WithSource sets the TOML document read by Decode.
+func WithSource(src Source) Option {
+	return WithSources(src)
+}
+
+// WithSources sets the TOML documents read by Decode in order, for example
+// File("config.toml"), EnvFile("config.{env}.toml") and OptionalFile("config.local.toml").
+// Their tables are merged key by key, the later documents overwriting the earlier ones,
+// before the environment sections of all documents are applied.
+func WithSources(srcs ...Source) Option {
+	return func(d *Decoder) {
+		d.sources = srcs
+	}
+}
+
+// File is a Source reading the TOML file at path.
+func File(path string) Source {
+	return Source{name: path, load: func() (*toml.TomlTree, error) {
+		return toml.LoadFile(path)
+	}}
+}
+
+// OptionalFile is like File but the Source is skipped if the file does not exist.
+func OptionalFile(path string) Source {
+	src := File(path)
+	src.optional = true
+	return src
+}
+
+// EnvFile is a Source reading, for each environment given by WithEnvironment, WithOverlays
+// or WithEnvironmentFrom, the TOML file at pattern with "{env}" replaced by the environment,
+// for example "config.{env}.toml". Files that do not exist are skipped.
+func EnvFile(pattern string) Source {
+	return Source{name: pattern, perEnv: func(env string) Source {
+		return OptionalFile(strings.ReplaceAll(pattern, envPlaceholder, env))
+	}}
+}
+
+// Bytes is a Source reading the TOML document in b.
+func Bytes(b []byte) Source {
+	return Source{load: func() (*toml.TomlTree, error) {
+		return toml.LoadReader(bytes.NewReader(b))
+	}}
+}
+
+// Reader is a Source reading the TOML document from r.
+func Reader(r io.Reader) Source {
+	return Source{load: func() (*toml.TomlTree, error) {
+		return toml.LoadReader(r)
+	}}
+}
+
+// FS is a Source reading the named TOML file from fsys.
+func FS(fsys fs.FS, name string) Source {
+	return Source{name: name, load: func() (*toml.TomlTree, error) {
+		f, err := fsys.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return toml.LoadReader(f)
+	}}
+}
+
+// Decode reads the sources of d and sets the values into v, which must be a struct pointer.
+func (d *Decoder) Decode(v interface{}) error {
+	if len(d.sources) == 0 {
+		return errors.New("source is not set")
+	}
+	docs, err := d.loadSources()
+	if err != nil {
+		return err
+	}
+	return d.decode(v, docs)
+}
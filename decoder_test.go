@@ -0,0 +1,71 @@
+package toml
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecoder(t *testing.T) {
+	type Conf struct {
+		UserName string
+		Password string `cfg:"pass"`
+		Port     int
+	}
+
+	b := []byte(`
+	UserName = "admin"
+	pass = "admin"
+	port = "80"
+	[development]
+	pass = "12345"
+	`)
+
+	// hook converts the port string
+	hook := func(t reflect.Type, v interface{}) (interface{}, error) {
+		if s, ok := v.(string); ok && t.Kind() == reflect.Int {
+			var n int64
+			_, err := fmt.Sscan(s, &n)
+			return n, err
+		}
+		return v, nil
+	}
+
+	d := NewDecoder(
+		WithEnvironment("development"),
+		WithTagName("cfg"),
+		WithNaming(func(s string) string {
+			if s == "Port" {
+				return strings.ToLower(s)
+			}
+			return s
+		}),
+		WithHook(hook),
+		WithSource(Bytes(b)),
+	)
+	c := &Conf{}
+	if err := d.Decode(c); err != nil {
+		t.Fatal(err)
+	}
+	if c.UserName != "admin" || c.Password != "12345" || c.Port != 80 {
+		t.Error(fmt.Sprintf("failed to decode conf: %v", c))
+	}
+
+	// default settings read the same file as Load
+	c2 := &struct {
+		User     string
+		Password string
+	}{}
+	if err := NewDecoder(WithEnvironment("production"), WithSource(File("test/config.toml"))).Decode(c2); err != nil {
+		t.Fatal(err)
+	}
+	if c2.User != "master user" || c2.Password != "master password" {
+		t.Error(fmt.Sprintf("failed to decode conf: %v", c2))
+	}
+
+	// no source
+	if err := NewDecoder().Decode(c); err == nil {
+		t.Error("expected error without source")
+	}
+}
@@ -0,0 +1,138 @@
+package toml
+
+import (
+	"fmt"
+	"github.com/pelletier/go-toml"
+	"os"
+	"strings"
+)
+
+// extendsKey is the key in an environment section naming its parent environment.
+const extendsKey = "extends"
+
+// environmentsKey is the table declaring the environments used in the TOML document, e.g.
+//
+//	[_environments]
+//	names = ["development", "staging", "production"]
+const environmentsKey = "_environments"
+
+// environmentKey is the top-level key naming the environment of the TOML document,
+// read if the environment is not given by an option nor by a variable of WithEnvironmentFrom.
+const environmentKey = "environment"
+
+// activeOverlays returns the overlays to load and where they come from, see Metadata.
+// Overlays given by an option take priority over those resolved by WithEnvironmentFrom.
+func (d *Decoder) activeOverlays(tree *toml.TomlTree) ([]string, string, error) {
+	for _, env := range d.overlays {
+		if env != "" {
+			return d.overlays, "option", nil
+		}
+	}
+	if !d.environmentFrom {
+		return nil, "", nil
+	}
+	for _, name := range d.environmentVars {
+		if env := os.Getenv(name); env != "" {
+			return []string{env}, "$" + name, nil
+		}
+	}
+	v := tree.Get(environmentKey)
+	if v == nil {
+		return nil, "", nil
+	}
+	env, ok := v.(string)
+	if !ok {
+		return nil, "", fmt.Errorf("%s must be a string: %v", environmentKey, v)
+	}
+	return []string{env}, "file", nil
+}
+
+// declaredEnvironments returns the environments declared by WithEnvironments and by the TOML document.
+func (d *Decoder) declaredEnvironments(tree *toml.TomlTree) ([]string, error) {
+	envs := append([]string{}, d.environments...)
+	v := tree.Get(environmentsKey)
+	if v == nil {
+		return envs, nil
+	}
+	p := createPath(environmentsKey, "names")
+	table, ok := v.(*toml.TomlTree)
+	if !ok {
+		return nil, fmt.Errorf("%s must be a table: %v", environmentsKey, v)
+	}
+	names, ok := table.Get("names").([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s must be an array of strings: %v", p, table.Get("names"))
+	}
+	for _, name := range names {
+		env, ok := name.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s must be an array of strings: %v", p, names)
+		}
+		envs = append(envs, env)
+	}
+	return envs, nil
+}
+
+// checkEnvironments returns an error if any of envs is not declared.
+// Nothing is checked if no environment is declared.
+func checkEnvironments(envs, declared []string) error {
+	if len(declared) == 0 {
+		return nil
+	}
+	known := map[string]bool{}
+	for _, env := range declared {
+		known[env] = true
+	}
+	for _, env := range envs {
+		if !known[env] {
+			return fmt.Errorf("%w: %q is not one of %s", ErrUnknownEnvironment, env, strings.Join(declared, ", "))
+		}
+	}
+	return nil
+}
+
+// lookupOrder returns the environments of overlays in the order values are looked up,
+// most specific first.
+func (d *Decoder) lookupOrder(tree *toml.TomlTree, overlays []string) ([]string, error) {
+	var envs []string
+	seen := map[string]bool{}
+	for i := len(overlays) - 1; i >= 0; i-- {
+		chain, err := d.environmentChain(tree, overlays[i])
+		if err != nil {
+			return nil, err
+		}
+		for _, env := range chain {
+			if !seen[env] {
+				seen[env] = true
+				envs = append(envs, env)
+			}
+		}
+	}
+	return envs, nil
+}
+
+// environmentChain returns env followed by its ancestors.
+// A parent is taken from WithParent or else from the "extends" key of the environment section.
+func (d *Decoder) environmentChain(tree *toml.TomlTree, env string) ([]string, error) {
+	var chain []string
+	seen := map[string]bool{}
+	for env != "" {
+		if seen[env] {
+			return nil, fmt.Errorf("environment inheritance cycle: %s -> %s", strings.Join(chain, " -> "), env)
+		}
+		seen[env] = true
+		chain = append(chain, env)
+
+		parent, ok := d.parents[env]
+		if !ok {
+			p := createPath(env, extendsKey)
+			if v := tree.Get(p); v != nil {
+				if parent, ok = v.(string); !ok {
+					return nil, fmt.Errorf("%s must be a string: %v", p, v)
+				}
+			}
+		}
+		env = parent
+	}
+	return chain, nil
+}
@@ -0,0 +1,319 @@
+package toml
+
+import (
+	"errors"
+	"fmt"
+	"github.com/pelletier/go-toml"
+	"reflect"
+	"testing"
+)
+
+func TestEnvironmentChain(t *testing.T) {
+	type Postgres struct {
+		User     string
+		Password string
+		Host     string
+	}
+
+	type Conf struct {
+		User          string
+		MaxConnection int
+		Debug         bool
+		Postgres      Postgres
+	}
+
+	b := []byte(`
+	user = "master user"
+	max_connection = 10
+	debug = true
+
+	[production]
+	max_connection = 100
+	debug = false
+
+	[staging]
+	extends = "production"
+	user = "staging user"
+
+	[qa]
+	extends = "staging"
+	max_connection = 5
+
+	[postgres]
+	user = "postgresuser"
+	password = "mypassword"
+	host = "localhost"
+
+	[postgres.production]
+	user = "rouser"
+	host = "db.example.com"
+
+	[postgres.staging]
+	host = "staging-db.example.com"
+	`)
+
+	// staging -> production -> default
+	{
+		c := &Conf{}
+		if err := LoadBytes(c, b, "staging"); err != nil {
+			t.Fatal(err)
+		}
+		if c.User != "staging user" || c.MaxConnection != 100 || c.Debug != false {
+			t.Error(fmt.Sprintf("failed to load conf: %v", c))
+		}
+		if c.Postgres.User != "rouser" || c.Postgres.Password != "mypassword" || c.Postgres.Host != "staging-db.example.com" {
+			t.Error(fmt.Sprintf("failed to load struct: %v", c))
+		}
+	}
+
+	// qa -> staging -> production -> default
+	{
+		c := &Conf{}
+		if err := LoadBytes(c, b, "qa"); err != nil {
+			t.Fatal(err)
+		}
+		if c.User != "staging user" || c.MaxConnection != 5 || c.Debug != false || c.Postgres.Host != "staging-db.example.com" {
+			t.Error(fmt.Sprintf("failed to load conf: %v", c))
+		}
+	}
+
+	// WithParent takes priority over extends
+	{
+		c := &Conf{}
+		d := NewDecoder(WithEnvironment("staging"), WithParent("staging", ""), WithSource(Bytes(b)))
+		if err := d.Decode(c); err != nil {
+			t.Fatal(err)
+		}
+		if c.User != "staging user" || c.MaxConnection != 10 || c.Postgres.User != "postgresuser" {
+			t.Error(fmt.Sprintf("failed to load conf: %v", c))
+		}
+	}
+
+	// cycle
+	{
+		c := &Conf{}
+		d := NewDecoder(WithEnvironment("staging"), WithParent("production", "qa"), WithSource(Bytes(b)))
+		if err := d.Decode(c); err == nil {
+			t.Error("expected inheritance cycle error")
+		}
+	}
+}
+
+func TestLookupOrder(t *testing.T) {
+	type Endpoint struct {
+		Host string
+		Port int
+	}
+
+	type Conf struct {
+		Region   string
+		Tenant   string
+		Replicas int
+		Debug    bool
+		Endpoint Endpoint
+	}
+
+	b := []byte(`
+	region = "local"
+	tenant = "none"
+	replicas = 1
+	debug = true
+
+	[production]
+	region = "us-east"
+	replicas = 3
+	debug = false
+
+	[production.eu-west]
+	region = "eu-west"
+	replicas = 5
+
+	[tenant-acme]
+	tenant = "acme"
+	replicas = 2
+
+	[staging]
+	extends = "production"
+
+	[endpoint]
+	host = "localhost"
+	port = 8080
+
+	[endpoint.production]
+	host = "api.example.com"
+
+	[endpoint.production.eu-west]
+	host = "eu.api.example.com"
+
+	[endpoint.tenant-acme]
+	port = 9090
+	`)
+
+	tree, err := toml.Load(string(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	examples := []struct {
+		Overlays []string
+		Order    []string
+		Conf     Conf
+	}{
+		{
+			Overlays: []string{"production"},
+			Order:    []string{"production"},
+			Conf:     Conf{Region: "us-east", Tenant: "none", Replicas: 3, Endpoint: Endpoint{Host: "api.example.com", Port: 8080}},
+		},
+		{
+			Overlays: []string{"production", "production.eu-west"},
+			Order:    []string{"production.eu-west", "production"},
+			Conf:     Conf{Region: "eu-west", Tenant: "none", Replicas: 5, Endpoint: Endpoint{Host: "eu.api.example.com", Port: 8080}},
+		},
+		{
+			Overlays: []string{"production", "production.eu-west", "tenant-acme"},
+			Order:    []string{"tenant-acme", "production.eu-west", "production"},
+			Conf:     Conf{Region: "eu-west", Tenant: "acme", Replicas: 2, Endpoint: Endpoint{Host: "eu.api.example.com", Port: 9090}},
+		},
+		{
+			Overlays: []string{"tenant-acme", "staging"},
+			Order:    []string{"staging", "production", "tenant-acme"},
+			Conf:     Conf{Region: "us-east", Tenant: "acme", Replicas: 3, Endpoint: Endpoint{Host: "api.example.com", Port: 9090}},
+		},
+	}
+
+	for _, example := range examples {
+		d := NewDecoder(WithOverlays(example.Overlays...), WithSource(Bytes(b)))
+		order, err := d.lookupOrder(tree, example.Overlays)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(order, example.Order) {
+			t.Error(fmt.Sprintln("Error:", example.Overlays, order))
+		}
+		c := &Conf{}
+		if err := d.Decode(c); err != nil {
+			t.Fatal(err)
+		}
+		if *c != example.Conf {
+			t.Error(fmt.Sprintf("Error: %v %+v", example.Overlays, c))
+		}
+	}
+}
+
+func TestDeclaredEnvironments(t *testing.T) {
+	type Postgres struct {
+		User string
+	}
+
+	type Conf struct {
+		User     string
+		Postgres Postgres
+	}
+
+	declared := []byte(`
+	user = "admin"
+
+	[_environments]
+	names = ["development", "staging", "production"]
+
+	[staging]
+	extends = "production"
+
+	[production]
+	user = "rouser"
+
+	[postgres]
+	user = "root"
+	`)
+
+	undeclared := []byte(`
+	user = "admin"
+
+	[production]
+	user = "rouser"
+
+	[postgres.prodcution]
+	user = "typo"
+	`)
+
+	examples := []struct {
+		Name    string
+		Opts    []Option
+		Success bool
+	}{
+		{Name: "declared in file", Opts: []Option{WithEnvironment("staging"), WithSource(Bytes(declared))}, Success: true},
+		{Name: "unknown environment in file", Opts: []Option{WithEnvironment("prodution"), WithSource(Bytes(declared))}, Success: false},
+		{Name: "not declared", Opts: []Option{WithEnvironment("prodution"), WithSource(Bytes(undeclared))}, Success: true},
+		{Name: "declared in code", Opts: []Option{WithEnvironment("production"), WithEnvironments("development", "production"), WithSource(Bytes(undeclared))}, Success: false},
+		{Name: "unknown environment in code", Opts: []Option{WithEnvironment("prodution"), WithEnvironments("development", "production"), WithSource(Bytes(undeclared))}, Success: false},
+		{Name: "undeclared parent", Opts: []Option{WithEnvironment("staging"), WithParent("staging", "qa"), WithEnvironments("staging", "production"), WithSource(Bytes(undeclared))}, Success: false},
+	}
+
+	for _, example := range examples {
+		c := &Conf{}
+		err := NewDecoder(example.Opts...).Decode(c)
+		if example.Success && err != nil {
+			t.Errorf("%s: %v", example.Name, err)
+		} else if !example.Success && !errors.Is(err, ErrUnknownEnvironment) {
+			t.Errorf("%s: expected unknown environment: %v", example.Name, err)
+		}
+		t.Log(err)
+	}
+}
+
+func TestEnvironmentFrom(t *testing.T) {
+	type Conf struct {
+		User string
+	}
+
+	b := []byte(`
+	environment = "staging"
+	user = "default"
+
+	[staging]
+	user = "stg"
+
+	[production]
+	user = "prd"
+	`)
+
+	examples := []struct {
+		Opts   []Option
+		AppEnv string
+		GoEnv  string
+		User   string
+		Env    string
+		Source string
+	}{
+		{Opts: []Option{WithEnvironment("production")}, AppEnv: "staging", User: "prd", Env: "production", Source: "option"},
+		{AppEnv: "production", GoEnv: "staging", User: "prd", Env: "production", Source: "$APP_ENV"},
+		{GoEnv: "production", User: "prd", Env: "production", Source: "$GO_ENV"},
+		{User: "stg", Env: "staging", Source: "file"},
+	}
+
+	for _, example := range examples {
+		t.Setenv("APP_ENV", example.AppEnv)
+		t.Setenv("GO_ENV", example.GoEnv)
+		var md Metadata
+		opts := append([]Option{WithSource(Bytes(b)), WithEnvironmentFrom("APP_ENV", "GO_ENV"), WithMetadata(&md), WithStrict(), WithEnvironments("staging", "production")}, example.Opts...)
+		c := &Conf{}
+		if err := NewDecoder(opts...).Decode(c); err != nil {
+			t.Fatal(err)
+		}
+		if c.User != example.User || md.Environment != example.Env || md.EnvironmentSource != example.Source {
+			t.Error(fmt.Sprintf("Error: %+v %+v %+v", example, c, md))
+		}
+	}
+
+	// not resolved without WithEnvironmentFrom
+	{
+		var md Metadata
+		c := &Conf{}
+		if err := NewDecoder(WithSource(Bytes(b)), WithMetadata(&md)).Decode(c); err != nil {
+			t.Fatal(err)
+		}
+		if c.User != "default" || md.Environment != "" || md.EnvironmentSource != "" {
+			t.Error(fmt.Sprintf("Error: %+v %+v", c, md))
+		}
+	}
+}
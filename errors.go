@@ -0,0 +1,132 @@
+package toml
+
+import (
+	"errors"
+	"fmt"
+	"github.com/pelletier/go-toml"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotFound is reported when a key is not defined in the TOML document.
+	ErrNotFound = errors.New("path not found")
+	// ErrInvalidType is reported when a TOML value cannot be set to the Go type.
+	ErrInvalidType = errors.New("invalid type")
+	// ErrOverflow is reported when a TOML number does not fit the Go type.
+	ErrOverflow = errors.New("overflow")
+	// ErrUnknownKey is reported in strict mode for a key that no field reads.
+	ErrUnknownKey = errors.New("unknown key")
+	// ErrUnknownEnvironment is reported when environments are declared, for a requested
+	// environment or an environment section that is not one of them, and in strict mode
+	// for a section of an undeclared environment.
+	ErrUnknownEnvironment = errors.New("unknown environment")
+)
+
+// A LoadError describes a setting that could not be loaded.
+type LoadError struct {
+	// Field is the path of the struct field, e.g. "Postgres.Tables[1]".
+	Field string
+	// Key is the TOML key path, e.g. "postgres.production.tables".
+	Key string
+	// Env is the environment section the key was read from, empty for the default settings.
+	Env string
+	// Expected is the Go type of the field.
+	Expected string
+	// Actual is the TOML type of the value.
+	Actual string
+	// File is the name of the TOML document, if known.
+	File string
+	// Line and Col are the position of the key in the TOML document, if known.
+	Line int
+	Col  int
+	// Err is the underlying error, such as ErrNotFound, ErrInvalidType or ErrOverflow.
+	Err error
+}
+
+func (e *LoadError) Error() string {
+	var b strings.Builder
+	if e.File != "" {
+		b.WriteString(e.File)
+		b.WriteString(":")
+	}
+	if e.Line > 0 {
+		fmt.Fprintf(&b, "%d:%d:", e.Line, e.Col)
+	}
+	if b.Len() > 0 {
+		b.WriteString(" ")
+	}
+	var details []string
+	switch {
+	case e.Field != "":
+		b.WriteString(e.Field)
+		if e.Key != "" {
+			details = append(details, fmt.Sprintf("key %q", e.Key))
+		}
+	case e.Key != "":
+		fmt.Fprintf(&b, "key %q", e.Key)
+	default:
+		b.WriteString("value")
+	}
+	if e.Env != "" {
+		details = append(details, fmt.Sprintf("environment %q", e.Env))
+	}
+	if len(details) > 0 {
+		fmt.Fprintf(&b, " (%s)", strings.Join(details, ", "))
+	}
+
+	fmt.Fprintf(&b, ": %v", e.Err)
+	if e.Expected != "" && e.Actual != "" {
+		fmt.Fprintf(&b, ": expected %s, got %s", e.Expected, e.Actual)
+	}
+	return b.String()
+}
+
+func (e *LoadError) Unwrap() error {
+	return e.Err
+}
+
+// LoadErrors is the list of errors returned for missing required keys, for unknown keys and
+// environments, and for every error of a Decoder configured WithAllErrors.
+// Without WithAllErrors, it ends with the first other error, if any.
+type LoadErrors []*LoadError
+
+func (e LoadErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d errors:\n\t%s", len(e), strings.Join(msgs, "\n\t"))
+}
+
+func (e LoadErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+	return errs
+}
+
+// tomlType returns the TOML name of the type of v.
+func tomlType(v interface{}) string {
+	switch v.(type) {
+	case int64:
+		return "integer"
+	case float64:
+		return "float"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case time.Time:
+		return "datetime"
+	case []interface{}:
+		return "array"
+	case *toml.TomlTree:
+		return "table"
+	case []*toml.TomlTree:
+		return "array of tables"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
@@ -0,0 +1,159 @@
+package toml
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestLoadError(t *testing.T) {
+	b := []byte(`user = "admin"
+
+[postgres]
+user = "postgresuser"
+port = 5432
+tables = ["user", "password"]
+
+[postgres.production]
+user = 1
+port = 1
+tables = [1, 2]
+`)
+
+	examples := []struct {
+		Name string
+		Conf interface{}
+		Env  string
+		Err  error
+		Want LoadError
+	}{
+		{
+			Name: "type mismatch in environment section",
+			Conf: &struct{ Postgres struct{ User string } }{},
+			Env:  "production",
+			Err:  ErrInvalidType,
+			Want: LoadError{Field: "Postgres.User", Key: "postgres.production.user", Env: "production", Expected: "string", Actual: "integer", File: "test.toml", Line: 9, Col: 1},
+		},
+		{
+			Name: "overflow",
+			Conf: &struct{ Postgres struct{ Port uint8 } }{},
+			Env:  "development",
+			Err:  ErrOverflow,
+			Want: LoadError{Field: "Postgres.Port", Key: "postgres.port", Expected: "uint8", Actual: "integer", File: "test.toml", Line: 5, Col: 1},
+		},
+		{
+			Name: "array element",
+			Conf: &struct{ Postgres struct{ Tables []string } }{},
+			Env:  "production",
+			Err:  ErrInvalidType,
+			Want: LoadError{Field: "Postgres.Tables[0]", Key: "postgres.production.tables[0]", Env: "production", Expected: "string", Actual: "integer", File: "test.toml", Line: 11, Col: 1},
+		},
+		{
+			Name: "array element of default settings",
+			Conf: &struct{ Postgres struct{ Tables []int } }{},
+			Env:  "development",
+			Err:  ErrInvalidType,
+			Want: LoadError{Field: "Postgres.Tables[0]", Key: "postgres.tables[0]", Expected: "int", Actual: "string", File: "test.toml", Line: 6, Col: 1},
+		},
+		{
+			Name: "not found",
+			Conf: &struct {
+				Postgres struct {
+					Host string `toml:",required"`
+				}
+			}{},
+			Env:  "production",
+			Err:  ErrNotFound,
+			Want: LoadError{Field: "Postgres.Host", Key: "postgres.host", File: "test.toml", Line: 3, Col: 1},
+		},
+		{
+			Name: "not a table",
+			Conf: &struct{ User struct{ Name string } }{},
+			Env:  "production",
+			Err:  ErrInvalidType,
+			Want: LoadError{Field: "User", Key: "user", Expected: "struct { Name string }", Actual: "string", File: "test.toml", Line: 1, Col: 1},
+		},
+	}
+
+	for _, example := range examples {
+		d := NewDecoder(WithEnvironment(example.Env), WithSource(Source{name: "test.toml", load: Bytes(b).load}))
+		err := d.Decode(example.Conf)
+		var le *LoadError
+		if !errors.As(err, &le) {
+			t.Errorf("%s: expected LoadError: %v", example.Name, err)
+			continue
+		}
+		if !errors.Is(err, example.Err) {
+			t.Errorf("%s: expected %v: %v", example.Name, example.Err, err)
+		}
+		got := *le
+		got.Err = nil
+		if got != example.Want {
+			t.Error(fmt.Sprintf("%s: %+v", example.Name, got))
+		}
+		t.Log(err)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	type Postgres struct {
+		User   string
+		Port   uint8
+		Tables []int
+	}
+
+	type Conf struct {
+		User     string
+		Password string `toml:"password,required"`
+		Timeout  int
+		Postgres Postgres
+	}
+
+	b := []byte(`
+	user = "admin"
+	timeout = "10"
+
+	[postgres]
+	user = "postgresuser"
+	port = 5432
+	tables = [1, 2]
+
+	[postgres.production]
+	tables = ["user", "password"]
+	`)
+
+	// stop at the first error, keeping the missing required keys found before it
+	{
+		c := &Conf{}
+		err := NewDecoder(WithEnvironment("production"), WithSource(Bytes(b))).Decode(c)
+		errs, ok := err.(LoadErrors)
+		if !ok || len(errs) != 2 || errs[0].Field != "Password" || errs[1].Field != "Timeout" {
+			t.Errorf("unexpected errors: %v", err)
+		}
+	}
+
+	// all errors
+	{
+		c := &Conf{}
+		err := NewDecoder(WithEnvironment("production"), WithAllErrors(), WithSource(Bytes(b))).Decode(c)
+		errs, ok := err.(LoadErrors)
+		if !ok {
+			t.Fatalf("expected LoadErrors: %v", err)
+		}
+		var fields []string
+		for _, e := range errs {
+			fields = append(fields, e.Field)
+		}
+		want := []string{"Password", "Timeout", "Postgres.Port", "Postgres.Tables[0]", "Postgres.Tables[1]"}
+		if fmt.Sprint(fields) != fmt.Sprint(want) {
+			t.Errorf("unexpected errors: %v", err)
+		}
+		if !errors.Is(err, ErrNotFound) || !errors.Is(err, ErrOverflow) || !errors.Is(err, ErrInvalidType) {
+			t.Errorf("unexpected errors: %v", err)
+		}
+		if c.User != "admin" || c.Postgres.User != "postgresuser" {
+			t.Error(fmt.Sprintf("failed to load conf: %v", c))
+		}
+		t.Log(err)
+	}
+}
@@ -0,0 +1,125 @@
+package toml
+
+import (
+	"errors"
+	"fmt"
+	"github.com/pelletier/go-toml"
+	"io/fs"
+)
+
+// envPlaceholder is replaced by the environment in the file names of EnvFile.
+const envPlaceholder = "{env}"
+
+// A document is a loaded TOML document.
+type document struct {
+	tree *toml.TomlTree
+	file string
+}
+
+// loadSources loads the sources of d in order. The files of EnvFile are loaded for the
+// environments given by options or resolved from the other sources.
+func (d *Decoder) loadSources() ([]document, error) {
+	loaded := make([]*document, len(d.sources))
+	var docs []document
+	perEnv := false
+	for i, src := range d.sources {
+		if src.perEnv != nil {
+			perEnv = true
+			continue
+		}
+		doc, err := src.open()
+		if err != nil {
+			return nil, err
+		}
+		if doc != nil {
+			loaded[i] = doc
+			docs = append(docs, *doc)
+		}
+	}
+	if !perEnv {
+		return docs, nil
+	}
+
+	overlays, _, err := d.activeOverlays(mergeTrees(docs))
+	if err != nil {
+		return nil, err
+	}
+	docs = nil
+	for i, src := range d.sources {
+		if src.perEnv == nil {
+			if loaded[i] != nil {
+				docs = append(docs, *loaded[i])
+			}
+			continue
+		}
+		for _, env := range overlays {
+			if env == "" {
+				continue
+			}
+			doc, err := src.perEnv(env).open()
+			if err != nil {
+				return nil, err
+			}
+			if doc != nil {
+				docs = append(docs, *doc)
+			}
+		}
+	}
+	return docs, nil
+}
+
+// open loads the document of src, or returns nil if src is optional and does not exist.
+func (src Source) open() (*document, error) {
+	tree, err := src.load()
+	if err != nil {
+		if src.optional && errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		if src.name != "" {
+			return nil, fmt.Errorf("%s: %w", src.name, err)
+		}
+		return nil, err
+	}
+	return &document{tree: tree, file: src.name}, nil
+}
+
+// documentLayers returns the layers of docs, the later documents first.
+func (s *decodeState) documentLayers(docs []document) layers {
+	ls := make(layers, 0, len(docs))
+	for i := len(docs) - 1; i >= 0; i-- {
+		ls = append(ls, layer{tree: docs[i].tree, level: len(s.envs), file: docs[i].file})
+	}
+	return ls
+}
+
+// mergeTrees returns the tables of docs merged key by key, the later documents overwriting
+// the earlier ones. It is used to read the settings of the environments, such as "extends",
+// which do not need the position of the key.
+func mergeTrees(docs []document) *toml.TomlTree {
+	if len(docs) == 1 {
+		return docs[0].tree
+	}
+	merged, _ := toml.Load("")
+	for _, doc := range docs {
+		mergeTree(merged, doc.tree, nil)
+	}
+	return merged
+}
+
+// mergeTree merges src into dst at the key path given as its keys,
+// so that quoted keys containing dots are kept whole.
+func mergeTree(dst, src *toml.TomlTree, prefix []string) {
+	for _, k := range src.Keys() {
+		path := append(append([]string{}, prefix...), k)
+		v := src.GetPath([]string{k})
+		if table, ok := v.(*toml.TomlTree); ok {
+			if _, ok := dst.GetPath(path).(*toml.TomlTree); !ok {
+				empty, _ := toml.Load("")
+				dst.SetPath(path, empty)
+			}
+			mergeTree(dst, table, path)
+			continue
+		}
+		dst.SetPath(path, v)
+	}
+}
@@ -0,0 +1,145 @@
+package toml
+
+import (
+	"errors"
+	"fmt"
+	"github.com/pelletier/go-toml"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadFiles(t *testing.T) {
+	type Postgres struct {
+		User string
+		Port int
+		Host string
+	}
+
+	type Conf struct {
+		Name     string
+		Debug    bool
+		Tags     []string
+		Postgres Postgres
+	}
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"config.toml": `name = "app"
+tags = ["a"]
+
+[postgres]
+user = "admin"
+port = 5432
+host = "localhost"
+
+[production]
+name = "app-prd"
+`,
+		"config.production.toml": `tags = ["b"]
+
+[postgres]
+host = "db.prod"
+port = 5433
+`,
+		"config.local.toml": `debug = true
+
+[postgres]
+port = 15432
+`,
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	base := filepath.Join(dir, "config.toml")
+	envFile := filepath.Join(dir, "config.{env}.toml")
+	local := filepath.Join(dir, "config.local.toml")
+
+	// production
+	{
+		c := &Conf{}
+		if err := LoadFiles(c, []string{base, envFile, local}, "production"); err != nil {
+			t.Fatal(err)
+		}
+		want := Conf{Name: "app-prd", Debug: true, Tags: []string{"b"}, Postgres: Postgres{User: "admin", Port: 15432, Host: "db.prod"}}
+		if fmt.Sprint(*c) != fmt.Sprint(want) {
+			t.Error(fmt.Sprintf("failed to load files: %+v", c))
+		}
+	}
+
+	// missing optional files
+	{
+		c := &Conf{}
+		if err := LoadFiles(c, []string{base, envFile, filepath.Join(dir, "missing.toml")}, "staging"); err != nil {
+			t.Fatal(err)
+		}
+		if c.Postgres.Port != 5432 || c.Debug {
+			t.Error(fmt.Sprintf("failed to skip missing files: %+v", c))
+		}
+	}
+
+	// missing first file
+	{
+		c := &Conf{}
+		if err := LoadFiles(c, []string{filepath.Join(dir, "missing.toml"), base}, ""); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected not exist: %v", err)
+		}
+	}
+
+	// errors and provenance name the file of the value
+	{
+		bad := filepath.Join(dir, "config.bad.toml")
+		if err := os.WriteFile(bad, []byte("name = \"bad\"\n\n[postgres]\nport = \"5433\"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		var md Metadata
+		c := &Conf{}
+		err := NewDecoder(WithSources(File(base), EnvFile(envFile)), WithEnvironment("bad"), WithAllErrors(), WithMetadata(&md)).Decode(c)
+		var le *LoadError
+		if !errors.As(err, &le) || le.File != bad || le.Line != 4 || le.Key != "postgres.port" {
+			t.Errorf("expected error in %s: %v", bad, err)
+		}
+		if o := md.Provenance["Name"]; o.File != bad || o.Line != 1 {
+			t.Errorf("expected name from %s: %+v", bad, o)
+		}
+		if o := md.Provenance["Postgres.User"]; o.File != base || o.Line != 5 {
+			t.Errorf("expected user from %s: %+v", base, o)
+		}
+		if fmt.Sprint(md.Files) != fmt.Sprint([]string{base, bad}) {
+			t.Errorf("unexpected files: %v", md.Files)
+		}
+	}
+
+	// strict mode
+	{
+		c := &Conf{}
+		err := NewDecoder(WithSources(File(base), File(local), Bytes([]byte("unknown = 1"))), WithStrict(), WithEnvironments("production")).Decode(c)
+		var le *LoadError
+		if !errors.As(err, &le) || le.Key != "unknown" || le.File != "" || !errors.Is(err, ErrUnknownKey) {
+			t.Errorf("expected unknown key: %v", err)
+		}
+	}
+}
+
+func TestMergeTrees(t *testing.T) {
+	var docs []document
+	for _, data := range []string{
+		"[hosts]\n\"example.com\" = \"a\"\n",
+		"[hosts]\n\"example.org\" = \"b\"\n\n[\"a.b\"]\nc = 1\n",
+	} {
+		tree, err := toml.Load(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		docs = append(docs, document{tree: tree})
+	}
+	merged := mergeTrees(docs)
+	if merged.GetPath([]string{"hosts", "example.com"}) != "a" || merged.GetPath([]string{"hosts", "example.org"}) != "b" {
+		t.Errorf("failed to merge quoted keys: %v", merged)
+	}
+	if merged.GetPath([]string{"a.b", "c"}) != int64(1) || merged.GetPath([]string{"a"}) != nil {
+		t.Errorf("failed to merge quoted tables: %v", merged)
+	}
+}
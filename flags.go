@@ -0,0 +1,160 @@
+package toml
+
+import (
+	"flag"
+	"fmt"
+	"go/ast"
+	"reflect"
+	"strings"
+)
+
+// BindFlags defines a flag on fs for each setting of the struct pointed to by v, named by its
+// key path like "database.port" and described by the `desc` tag of its field, and a repeatable
+// "set" flag taking key=value. Values given by these flags override the settings of every
+// environment and the environment variables of WithEnvOverrides when d decodes.
+//
+// Values are converted like default values and checked when the flags are parsed.
+// A pointer to a struct is allocated when a flag sets one of its fields, even if its table
+// is not defined. Fields in arrays of tables have no flags, and neither have the fields of
+// a struct nested in itself. An error is returned without defining any flag if a name is
+// already defined on fs.
+func (d *Decoder) BindFlags(fs *flag.FlagSet, v interface{}) error {
+	rt := reflect.TypeOf(v)
+	if rt == nil || rt.Kind() != reflect.Ptr || rt.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("v must be a struct pointer")
+	}
+	s := d.newDecodeState(nil, "")
+	fields := s.bindFields(rt.Elem(), "", map[reflect.Type]bool{}, nil)
+	keys := map[string]reflect.Type{}
+	for _, f := range fields {
+		if _, ok := keys[f.key]; ok || f.key == setFlagName || fs.Lookup(f.key) != nil {
+			return fmt.Errorf("flag -%s is already defined", f.key)
+		}
+		keys[f.key] = f.t
+	}
+	if fs.Lookup(setFlagName) != nil {
+		return fmt.Errorf("flag -%s is already defined", setFlagName)
+	}
+	if d.flags == nil {
+		d.flags = map[string]string{}
+	}
+	for _, f := range fields {
+		fs.Var(&flagValue{s: s, key: f.key, t: f.t}, f.key, f.desc)
+	}
+	fs.Var(&setFlag{s: s, keys: keys}, setFlagName, "override the setting at a key path, e.g. -set database.port=5433")
+	return nil
+}
+
+// setFlagName is the name of the flag taking key=value.
+const setFlagName = "set"
+
+// A flagField is a setting bound to a flag.
+type flagField struct {
+	key  string
+	t    reflect.Type
+	desc string
+}
+
+// bindFields appends the settings of the fields of the struct type t at the key path prefix to fields.
+// seen holds the struct types being bound, since pointer fields may refer back to them.
+func (s *decodeState) bindFields(t reflect.Type, prefix string, seen map[reflect.Type]bool, fields []flagField) []flagField {
+	if seen[t] {
+		return fields
+	}
+	seen[t] = true
+	defer delete(seen, t)
+	for i := 0; i < t.NumField(); i++ {
+		ft := t.Field(i)
+		tag := s.getFieldTag(ft)
+		if tag.skip || !ast.IsExported(ft.Name) && !(tag.inline && ft.Type.Kind() == reflect.Struct) {
+			continue
+		}
+		if tag.inline {
+			fields = s.bindFields(indirect(ft.Type), prefix, seen, fields)
+			continue
+		}
+		key := createPath(prefix, tag.name)
+		if et := indirect(ft.Type); et.Kind() == reflect.Struct && !isUnmarshaler(et) {
+			fields = s.bindFields(et, key, seen, fields)
+			continue
+		}
+		fields = append(fields, flagField{key: key, t: ft.Type, desc: ft.Tag.Get("desc")})
+	}
+	return fields
+}
+
+// setFlag checks that value can be set to a field of type t and records it for key.
+// Each value is checked with its own state, so the errors of a rejected value are not kept.
+func (s *decodeState) setFlag(key string, t reflect.Type, value string) error {
+	vs := s.newDecodeState(s.envs, "")
+	def := layer{tree: defaultTree(t, value), level: len(vs.envs)}
+	_, err := vs.getValue(t, layers{def}, defaultKey)
+	if err == nil && len(vs.errs) > 0 {
+		err = vs.errs[0]
+	}
+	if err != nil {
+		e := &LoadError{Key: key, Err: err}
+		if le, ok := err.(*LoadError); ok {
+			e.Expected, e.Actual, e.Err = le.Expected, le.Actual, le.Err
+		}
+		return e
+	}
+	s.flags[key] = value
+	return nil
+}
+
+// lookupFlag returns the flag overriding the field with tag in ls and its value.
+func (s *decodeState) lookupFlag(ls layers, tag fieldTag) (name, value string, ok bool) {
+	if len(s.flags) == 0 || strings.Contains(ls[0].key, "[") {
+		return "", "", false
+	}
+	name = s.normalize(createPath(ls[0].key, tag.name))
+	value, ok = s.flags[name]
+	return name, value, ok
+}
+
+// A flagValue is the flag.Value of the setting at key.
+type flagValue struct {
+	s   *decodeState
+	key string
+	t   reflect.Type
+}
+
+func (f *flagValue) String() string {
+	if f == nil || f.s == nil {
+		return ""
+	}
+	return f.s.flags[f.key]
+}
+
+func (f *flagValue) Set(value string) error {
+	return f.s.setFlag(f.key, f.t, value)
+}
+
+// IsBoolFlag allows boolean settings to be set by a flag without a value.
+func (f *flagValue) IsBoolFlag() bool {
+	return indirect(f.t).Kind() == reflect.Bool
+}
+
+// A setFlag is the flag.Value of the "set" flag.
+type setFlag struct {
+	s    *decodeState
+	keys map[string]reflect.Type
+}
+
+func (f *setFlag) String() string {
+	return ""
+}
+
+func (f *setFlag) Set(v string) error {
+	i := strings.Index(v, "=")
+	if i < 0 {
+		return fmt.Errorf("%q is not key=value", v)
+	}
+	key, value := v[:i], v[i+1:]
+	t, ok := f.keys[key]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownKey, key)
+	}
+	return f.s.setFlag(key, t, value)
+}
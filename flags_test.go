@@ -0,0 +1,205 @@
+package toml
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBindFlags(t *testing.T) {
+	type Database struct {
+		Host    string        `desc:"database host"`
+		Port    uint16        `desc:"database port"`
+		Timeout time.Duration `desc:"query timeout"`
+		Tables  []string
+		Ports   []uint
+	}
+
+	type Server struct {
+		IP string
+	}
+
+	type Conf struct {
+		Name     string
+		Debug    bool
+		Database Database
+		Servers  []Server
+		Secret   string `toml:"-"`
+		Token    *string
+		Enabled  *string
+	}
+
+	b := []byte(`
+	name = "app"
+
+	[database]
+	host = "localhost"
+	port = 5432
+
+	[production.database]
+	host = "db.prod"
+	port = 5433
+	`)
+
+	d := NewDecoder(WithSource(Bytes(b)), WithEnvironment("production"))
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	if err := d.BindFlags(fs, &Conf{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"name", "debug", "database.host", "database.port", "database.timeout", "database.tables", "set"} {
+		if fs.Lookup(name) == nil {
+			t.Errorf("flag %s is not defined", name)
+		}
+	}
+	if fs.Lookup("secret") != nil || fs.Lookup("servers.ip") != nil {
+		t.Error("unexpected flags")
+	}
+	if usage := fs.Lookup("database.port").Usage; usage != "database port" {
+		t.Errorf("usage: %q", usage)
+	}
+
+	args := []string{"-debug", "-token", "42", "-enabled", "true", "-database.port", "6543", "-set", "database.timeout=5s", "-set", `database.tables=["a", "b"]`}
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	c := &Conf{}
+	if err := d.Decode(c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Name != "app" || !c.Debug || c.Database.Host != "db.prod" || c.Database.Port != 6543 || c.Database.Timeout != 5*time.Second || fmt.Sprint(c.Database.Tables) != "[a b]" {
+		t.Error(fmt.Sprintf("failed to override by flags: %+v", c))
+	}
+	if c.Token == nil || *c.Token != "42" || c.Enabled == nil || *c.Enabled != "true" {
+		t.Error(fmt.Sprintf("failed to override string pointers by flags: %+v", c))
+	}
+
+	// invalid values
+	for _, args := range [][]string{
+		{"-database.port", "70000"},
+		{"-database.port", "many"},
+		{"-set", "database.port=-1"},
+		{"-set", "database.user=admin"},
+		{"-set", "database.port"},
+	} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		if err := NewDecoder().BindFlags(fs, &Conf{}); err != nil {
+			t.Fatal(err)
+		}
+		err := fs.Parse(args)
+		if err == nil || !strings.Contains(err.Error(), "invalid value") {
+			t.Errorf("%v: expected invalid value: %v", args, err)
+		}
+	}
+
+	// overflow is reported like values in the file
+	{
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		d := NewDecoder()
+		if err := d.BindFlags(fs, &Conf{}); err != nil {
+			t.Fatal(err)
+		}
+		var le *LoadError
+		err := fs.Lookup("database.port").Value.Set("70000")
+		if !errors.As(err, &le) || !errors.Is(err, ErrOverflow) || le.Key != "database.port" || le.Expected != "uint16" {
+			t.Errorf("expected overflow: %v", err)
+		}
+	}
+
+	// a rejected value does not fail the values set after it
+	{
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		d := NewDecoder(WithAllErrors())
+		if err := d.BindFlags(fs, &Conf{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := fs.Parse([]string{"-database.ports", "[1, -1]"}); err == nil {
+			t.Error("expected invalid value")
+		}
+		if err := fs.Parse([]string{"-database.port", "5"}); err != nil {
+			t.Errorf("failed to set a flag after a rejected value: %v", err)
+		}
+	}
+}
+
+func TestBindFlags_pointerTable(t *testing.T) {
+	type TLS struct {
+		Cert string
+		Key  string
+	}
+	type Conf struct {
+		Name  string
+		TLS   *TLS
+		Proxy *TLS
+	}
+
+	d := NewDecoder(WithSource(Bytes([]byte(`name = "app"`))))
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	if err := d.BindFlags(fs, &Conf{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Parse([]string{"-tls.cert", "cert.pem"}); err != nil {
+		t.Fatal(err)
+	}
+	c := &Conf{}
+	if err := d.Decode(c); err != nil {
+		t.Fatal(err)
+	}
+	if c.TLS == nil || c.TLS.Cert != "cert.pem" || c.TLS.Key != "" {
+		t.Error(fmt.Sprintf("failed to override a missing pointer table: %+v", c.TLS))
+	}
+	if c.Proxy != nil {
+		t.Error(fmt.Sprintf("expected a missing pointer table without flags to be nil: %+v", c.Proxy))
+	}
+}
+
+func TestBindFlags_recursive(t *testing.T) {
+	type node struct {
+		Name string
+		Next *node
+	}
+	type Conf struct {
+		Root node
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	if err := NewDecoder().BindFlags(fs, &Conf{}); err != nil {
+		t.Fatal(err)
+	}
+	if fs.Lookup("root.name") == nil || fs.Lookup("root.next.name") != nil {
+		t.Error("unexpected flags of a recursive struct")
+	}
+}
+
+func TestBindFlags_redefined(t *testing.T) {
+	type Conf struct {
+		Config string
+	}
+
+	for _, c := range []struct {
+		defined string
+		conf    interface{}
+		want    string
+	}{
+		{"config", &Conf{}, "-config"},
+		{"", &struct{ Set string }{}, "-set"},
+		{"set", &struct{ Name string }{}, "-set"},
+	} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		if c.defined != "" {
+			fs.String(c.defined, "", "")
+		}
+		err := NewDecoder().BindFlags(fs, c.conf)
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("expected %s to be already defined: %v", c.want, err)
+		}
+		if c.defined != "set" && fs.Lookup("set") != nil {
+			t.Error("flags are defined despite the error")
+		}
+	}
+}
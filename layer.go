@@ -0,0 +1,166 @@
+package toml
+
+import (
+	"github.com/pelletier/go-toml"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A layer is one of the TOML tables that make up a setting.
+type layer struct {
+	tree *toml.TomlTree
+	// key is the key path of tree in the TOML document.
+	key string
+	// env and level are the environment section the table belongs to and its index in the
+	// lookup order; the default settings have the level len(envs).
+	env   string
+	level int
+	// file is the name of the TOML document of the table, if known.
+	file string
+}
+
+// layers are the tables of a setting, most specific first.
+// Without deep merge there is only one table for each setting.
+type layers []layer
+
+// A found is a value found in a layer.
+type found struct {
+	layer layer
+	// path is the path of the value in the table of the layer.
+	path  string
+	env   string
+	level int
+}
+
+func (f found) get() interface{} {
+	return getPath(f.layer.tree, f.path)
+}
+
+func (f found) key() string {
+	return createPath(f.layer.key, f.path)
+}
+
+// table returns the value as a layer, if it is a table.
+func (f found) table() (layer, bool) {
+	tree, ok := f.get().(*toml.TomlTree)
+	return layer{tree: tree, key: f.key(), env: f.env, level: f.level, file: f.layer.file}, ok
+}
+
+// findAll returns every value of elem in ls, most specific first.
+// elem is looked up in each environment section of each layer and in the layer itself.
+func (s *decodeState) findAll(ls layers, elem string) []found {
+	var fs []found
+	for _, l := range ls {
+		for i := 0; i <= len(s.envs); i++ {
+			env := ""
+			if i < len(s.envs) {
+				env = s.envs[i]
+			}
+			p := createPath(env, elem)
+			if p == "" || getPath(l.tree, p) == nil {
+				continue
+			}
+			f := found{layer: l, path: p, env: env, level: i}
+			// a table in an environment section keeps the precedence of that environment
+			if l.level < i {
+				f.env, f.level = l.env, l.level
+			}
+			fs = append(fs, f)
+		}
+	}
+	sort.SliceStable(fs, func(i, j int) bool {
+		return fs[i].level < fs[j].level
+	})
+	return fs
+}
+
+// find returns the most specific value of elem in ls.
+func (s *decodeState) find(ls layers, elem string) (found, error) {
+	fs := s.findAll(ls, elem)
+	if len(fs) == 0 {
+		return found{}, s.notFound(ls, elem)
+	}
+	return fs[0], nil
+}
+
+// sections returns the layers and their environment sections, least specific first.
+func (s *decodeState) sections(ls layers) layers {
+	var sections layers
+	for i := len(ls) - 1; i >= 0; i-- {
+		l := ls[i]
+		sections = append(sections, l)
+		for level := len(s.envs) - 1; level >= 0; level-- {
+			env := s.envs[level]
+			tree, ok := l.tree.Get(env).(*toml.TomlTree)
+			if !ok {
+				continue
+			}
+			section := layer{tree: tree, key: createPath(l.key, env), env: env, level: level, file: l.file}
+			if l.level < level {
+				section.env, section.level = l.env, l.level
+			}
+			sections = append(sections, section)
+		}
+	}
+	sort.SliceStable(sections, func(i, j int) bool {
+		return sections[i].level > sections[j].level
+	})
+	return sections
+}
+
+// quoteKey returns k as a key in a key path, quoted if it contains dots or quotes,
+// for example the key of `"example.com" = "1.2.3.4"`.
+func quoteKey(k string) string {
+	if strings.ContainsAny(k, `."`) {
+		return strconv.Quote(k)
+	}
+	return k
+}
+
+// splitKeys returns the keys of path, keeping quoted keys and array indexes.
+func splitKeys(path string) []string {
+	var keys []string
+	start, quoted := 0, false
+	for i := 0; i < len(path); i++ {
+		switch c := path[i]; {
+		case c == '\\' && quoted:
+			i++
+		case c == '"':
+			quoted = !quoted
+		case c == '.' && !quoted:
+			keys = append(keys, path[start:i])
+			start = i + 1
+		}
+	}
+	return append(keys, path[start:])
+}
+
+// splitPath returns the keys of path, a key path in a table, with quoted keys unquoted.
+func splitPath(path string) []string {
+	keys := splitKeys(path)
+	for i, k := range keys {
+		if strings.HasPrefix(k, `"`) {
+			if u, err := strconv.Unquote(k); err == nil {
+				keys[i] = u
+			}
+		}
+	}
+	return keys
+}
+
+// getPath returns the value at path in tree, or tree itself if path is empty.
+func getPath(tree *toml.TomlTree, path string) interface{} {
+	if path == "" {
+		return tree
+	}
+	return tree.GetPath(splitPath(path))
+}
+
+// getPosition returns the position of the value at path in tree.
+func getPosition(tree *toml.TomlTree, path string) toml.Position {
+	if path == "" {
+		return tree.GetPosition("")
+	}
+	return tree.GetPositionPath(splitPath(path))
+}
@@ -0,0 +1,193 @@
+package toml
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDeepMerge(t *testing.T) {
+	type Pool struct {
+		Max     int
+		Min     int
+		Timeout int
+	}
+
+	type Database struct {
+		Host  string
+		Pool  Pool
+		Flags map[string]bool
+	}
+
+	type Conf struct {
+		Database Database
+	}
+
+	b := []byte(`
+	[database]
+	host = "localhost"
+
+	[database.pool]
+	max = 10
+	min = 1
+	timeout = 30
+
+	[database.flags]
+	readonly = false
+	trace = false
+
+	[production.database.pool]
+	max = 100
+
+	[production.database.flags]
+	readonly = true
+
+	[staging]
+	extends = "production"
+
+	[database.staging.pool]
+	timeout = 60
+
+	[database.development]
+	host = "127.0.0.1"
+	`)
+
+	examples := []struct {
+		Env       string
+		DeepMerge bool
+		Want      Database
+	}{
+		{Env: "development", DeepMerge: true, Want: Database{Host: "127.0.0.1", Pool: Pool{Max: 10, Min: 1, Timeout: 30}}},
+		{Env: "production", DeepMerge: true, Want: Database{Host: "localhost", Pool: Pool{Max: 100, Min: 1, Timeout: 30}}},
+		{Env: "staging", DeepMerge: true, Want: Database{Host: "localhost", Pool: Pool{Max: 100, Min: 1, Timeout: 60}}},
+		// without deep merge [production.database] replaces [database]
+		{Env: "production", DeepMerge: false, Want: Database{Pool: Pool{Max: 100}}},
+		{Env: "staging", DeepMerge: false, Want: Database{Pool: Pool{Max: 100}}},
+	}
+
+	for _, example := range examples {
+		opts := []Option{WithEnvironment(example.Env), WithSource(Bytes(b))}
+		if example.DeepMerge {
+			opts = append(opts, WithDeepMerge())
+		}
+		c := &Conf{}
+		if err := NewDecoder(opts...).Decode(c); err != nil {
+			t.Fatal(err)
+		}
+		if c.Database.Host != example.Want.Host || c.Database.Pool != example.Want.Pool {
+			t.Error(fmt.Sprintf("%s %v: failed to load conf: %+v", example.Env, example.DeepMerge, c))
+		}
+		readonly := example.Env != "development"
+		if example.DeepMerge && (len(c.Database.Flags) != 2 || c.Database.Flags["readonly"] != readonly) {
+			t.Error(fmt.Sprintf("%s: failed to load map: %+v", example.Env, c.Database.Flags))
+		}
+	}
+}
+
+func TestArrayMerge(t *testing.T) {
+	type Server struct {
+		Name   string
+		IP     string
+		Weight int
+	}
+
+	type Conf struct {
+		Replace []string `merge:"replace"`
+		Default []string
+		Append  []string `merge:"append"`
+		Prepend []string `merge:"prepend"`
+		Union   []string `merge:"union"`
+		Servers []Server `merge:"by=name"`
+	}
+
+	b := []byte(`
+	replace = ["a", "b"]
+	default = ["a", "b"]
+	append = ["a", "b"]
+	prepend = ["a", "b"]
+	union = ["a", "b"]
+
+	[[servers]]
+	name = "alpha"
+	ip = "10.0.0.1"
+	weight = 1
+
+	[[servers]]
+	name = "beta"
+	ip = "10.0.0.2"
+	weight = 1
+
+	[production]
+	replace = ["b", "c"]
+	default = ["b", "c"]
+	append = ["b", "c"]
+	prepend = ["b", "c"]
+	union = ["b", "c"]
+
+	[[production.servers]]
+	name = "beta"
+	weight = 5
+
+	[[production.servers]]
+	name = "gamma"
+	ip = "10.0.0.3"
+
+	[staging]
+	extends = "production"
+	append = ["d"]
+	`)
+
+	c := &Conf{}
+	if err := LoadBytes(c, b, "production"); err != nil {
+		t.Fatal(err)
+	}
+	examples := []struct {
+		Name string
+		Got  []string
+		Want string
+	}{
+		{Name: "replace", Got: c.Replace, Want: "[b c]"},
+		{Name: "default", Got: c.Default, Want: "[b c]"},
+		{Name: "append", Got: c.Append, Want: "[a b b c]"},
+		{Name: "prepend", Got: c.Prepend, Want: "[b c a b]"},
+		{Name: "union", Got: c.Union, Want: "[a b c]"},
+	}
+	for _, example := range examples {
+		if fmt.Sprint(example.Got) != example.Want {
+			t.Errorf("%s: %v", example.Name, example.Got)
+		}
+	}
+	if fmt.Sprintf("%+v", c.Servers) != "[{Name:alpha IP:10.0.0.1 Weight:1} {Name:beta IP:10.0.0.2 Weight:5} {Name:gamma IP:10.0.0.3 Weight:0}]" {
+		t.Errorf("by=name: %+v", c.Servers)
+	}
+
+	// inherited environments are merged too
+	c = &Conf{}
+	if err := LoadBytes(c, b, "staging"); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(c.Append) != "[a b b c d]" {
+		t.Errorf("append: %v", c.Append)
+	}
+
+	// unknown strategy
+	err := LoadBytes(&struct {
+		Append []string `merge:"concat"`
+	}{}, b, "production")
+	if err == nil {
+		t.Error("expected error for unknown merge strategy")
+	}
+}
+
+func TestSplitPath(t *testing.T) {
+	for path, want := range map[string][]string{
+		"":                               {""},
+		"postgres.user":                  {"postgres", "user"},
+		`hosts."example.com"`:            {"hosts", "example.com"},
+		`hosts."a \"b.c\"".production`:   {"hosts", `a "b.c"`, "production"},
+		createPath("x", quoteKey("a.b")): {"x", "a.b"},
+	} {
+		if got := splitPath(path); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("splitPath(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package toml
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+)
+
+// Metadata describes how Decode loaded the settings, for logging.
+type Metadata struct {
+	// Environment is the most specific environment loaded, empty for the default settings.
+	Environment string
+	// EnvironmentSource is where Environment comes from: "option" for WithEnvironment,
+	// WithOverlays and the env argument of Load, "$NAME" for a variable of
+	// WithEnvironmentFrom, "file" for the "environment" key of the TOML document,
+	// and empty if no environment is loaded.
+	EnvironmentSource string
+	// Files are the names of the TOML documents loaded, in order.
+	Files []string
+	// Provenance is the origin of the value of each field.
+	Provenance Provenance
+}
+
+// Sources of the value of a field.
+const (
+	// OriginFile is a value in the TOML document.
+	OriginFile = "file"
+	// OriginDefault is the default value of the field.
+	OriginDefault = "default"
+	// OriginEnvVar is an environment variable of WithEnvOverrides.
+	OriginEnvVar = "env"
+	// OriginFlag is a flag of BindFlags.
+	OriginFlag = "flag"
+	// OriginUnset is the zero value of a field whose key is not defined.
+	OriginUnset = "unset"
+)
+
+// An Origin is where the value of a field comes from.
+type Origin struct {
+	// Source is one of OriginFile, OriginDefault, OriginEnvVar, OriginFlag and OriginUnset.
+	Source string
+	// Key is the TOML key path of the value, e.g. "postgres.production.user".
+	Key string
+	// Env is the environment section the key was read from, empty for the default settings.
+	Env string
+	// File, Line and Col are the position of the key in the TOML document, if known.
+	File string
+	Line int
+	Col  int
+	// Name is the environment variable or the flag.
+	Name string
+}
+
+// Location returns the position of the key, or the environment variable or the flag.
+func (o Origin) Location() string {
+	switch {
+	case o.Name != "":
+		return o.Name
+	case o.Line > 0 && o.File != "":
+		return fmt.Sprintf("%s:%d:%d", o.File, o.Line, o.Col)
+	case o.Line > 0:
+		return fmt.Sprintf("%d:%d", o.Line, o.Col)
+	default:
+		return o.File
+	}
+}
+
+// Provenance maps the path of each struct field, e.g. "Postgres.Tables[1]", to its origin.
+type Provenance map[string]Origin
+
+// WriteTable writes p to w as a table sorted by field, for example
+//
+//	FIELD          SOURCE   KEY                       ENVIRONMENT  LOCATION
+//	Debug          env      debug                                  APP_DEBUG
+//	Postgres.Port  default  postgres.port
+//	Postgres.User  file     production.postgres.user  production   config.toml:11:1
+func (p Provenance) WriteTable(w io.Writer) error {
+	fields := make([]string, 0, len(p))
+	for field := range p {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FIELD\tSOURCE\tKEY\tENVIRONMENT\tLOCATION")
+	for _, field := range fields {
+		o := p[field]
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", field, o.Source, o.Key, o.Env, o.Location())
+	}
+	return tw.Flush()
+}
+
+// origin returns the origin of the value f.
+func (s *decodeState) origin(f found) Origin {
+	o := Origin{Source: OriginFile, Key: f.key(), Env: f.env, File: f.layer.file}
+	if pos := getPosition(f.layer.tree, f.path); f.layer.tree != emptyTree && !pos.Invalid() {
+		o.Line, o.Col = pos.Line, pos.Col
+	}
+	return o
+}
+
+// record records o as the origin of the current field if metadata is requested.
+func (s *decodeState) record(o Origin) {
+	if s.provenance != nil {
+		s.provenance[s.field] = o
+	}
+}
@@ -0,0 +1,79 @@
+package toml
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestProvenance(t *testing.T) {
+	type Postgres struct {
+		User     string
+		Password string
+		Port     int `default:"5432"`
+		Host     string
+	}
+
+	type Server struct {
+		IP string
+	}
+
+	type Conf struct {
+		Name     string
+		Debug    bool
+		Postgres Postgres
+		Servers  []Server
+	}
+
+	b := []byte(`name = "app"
+
+[postgres]
+user = "admin"
+password = "secret"
+
+[[servers]]
+ip = "10.0.0.1"
+
+[production.postgres]
+user = "prd"
+`)
+
+	t.Setenv("APP_DEBUG", "true")
+	var md Metadata
+	c := &Conf{}
+	fsys := fstest.MapFS{"test.toml": {Data: b}}
+	d := NewDecoder(WithSource(FS(fsys, "test.toml")), WithEnvironment("production"), WithDeepMerge(), WithEnvOverrides("APP"), WithMetadata(&md))
+	if err := d.Decode(c); err != nil {
+		t.Fatal(err)
+	}
+
+	examples := map[string]Origin{
+		"Name":              {Source: OriginFile, Key: "name", File: "test.toml", Line: 1, Col: 1},
+		"Debug":             {Source: OriginEnvVar, Key: "debug", Name: "APP_DEBUG"},
+		"Postgres":          {Source: OriginFile, Key: "production.postgres", Env: "production", File: "test.toml", Line: 10, Col: 1},
+		"Postgres.User":     {Source: OriginFile, Key: "production.postgres.user", Env: "production", File: "test.toml", Line: 11, Col: 1},
+		"Postgres.Password": {Source: OriginFile, Key: "postgres.password", File: "test.toml", Line: 5, Col: 1},
+		"Postgres.Port":     {Source: OriginDefault, Key: "postgres.port"},
+		"Postgres.Host":     {Source: OriginUnset, Key: "postgres.host"},
+		"Servers":           {Source: OriginFile, Key: "servers", File: "test.toml", Line: 7, Col: 1},
+		"Servers[0].IP":     {Source: OriginFile, Key: "servers[0].ip", File: "test.toml", Line: 8, Col: 1},
+	}
+	for field, want := range examples {
+		if got := md.Provenance[field]; got != want {
+			t.Errorf("%s: got %+v, want %+v", field, got, want)
+		}
+	}
+	if len(md.Provenance) != len(examples) {
+		t.Errorf("unexpected fields: %+v", md.Provenance)
+	}
+
+	var sb strings.Builder
+	if err := md.Provenance.WriteTable(&sb); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(sb.String()), "\n")
+	if len(lines) != len(examples)+1 || !strings.HasPrefix(lines[0], "FIELD") || !strings.Contains(sb.String(), "APP_DEBUG") || !strings.Contains(sb.String(), "test.toml:11:1") {
+		t.Error(fmt.Sprintf("failed to write table:\n%s", sb.String()))
+	}
+}
@@ -0,0 +1,81 @@
+package toml
+
+import (
+	"go/ast"
+	"os"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// lookupEnvVar returns the environment variable overriding the field with tag in ls and its value.
+// Fields in arrays of tables are not overridden, since their elements share the key path.
+func (s *decodeState) lookupEnvVar(ls layers, tag fieldTag) (name, value string, ok bool) {
+	if !s.envOverrides || strings.Contains(ls[0].key, "[") {
+		return "", "", false
+	}
+	name = s.envVar(s.normalize(createPath(ls[0].key, tag.name)), tag)
+	value, ok = os.LookupEnv(name)
+	return name, value, ok
+}
+
+// envVar returns the environment variable overriding the field with tag at the key path.
+func (s *decodeState) envVar(key string, tag fieldTag) string {
+	if tag.envVar != "" {
+		return tag.envVar
+	}
+	return envVarName(s.envPrefix, key)
+}
+
+// isOverridden reports whether a flag or an environment variable sets a field of the struct t at the key path,
+// so that a pointer to t is allocated even if its table is not defined.
+// seen holds the struct types being checked, since pointer fields may refer back to them.
+func (s *decodeState) isOverridden(t reflect.Type, key string, seen map[reflect.Type]bool) bool {
+	if !s.envOverrides && len(s.flags) == 0 || strings.Contains(key, "[") || seen[t] {
+		return false
+	}
+	seen[t] = true
+	defer delete(seen, t)
+	for i := 0; i < t.NumField(); i++ {
+		ft := t.Field(i)
+		tag := s.getFieldTag(ft)
+		if tag.skip || !ast.IsExported(ft.Name) && !(tag.inline && ft.Type.Kind() == reflect.Struct) {
+			continue
+		}
+		et := indirect(ft.Type)
+		if tag.inline {
+			if s.isOverridden(et, key, seen) {
+				return true
+			}
+			continue
+		}
+		k := s.normalize(createPath(key, tag.name))
+		if _, ok := s.flags[k]; ok {
+			return true
+		}
+		if s.envOverrides {
+			if _, ok := os.LookupEnv(s.envVar(k, tag)); ok {
+				return true
+			}
+		}
+		if et.Kind() == reflect.Struct && !isUnmarshaler(et) && s.isOverridden(et, k, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+// envVarName returns the environment variable of the key path after prefix,
+// with the characters other than letters and digits replaced by underscores.
+func envVarName(prefix, path string) string {
+	name := strings.Map(func(r rune) rune {
+		if r > unicode.MaxASCII || !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return '_'
+		}
+		return unicode.ToUpper(r)
+	}, path)
+	if prefix == "" {
+		return name
+	}
+	return prefix + "_" + name
+}
@@ -0,0 +1,159 @@
+package toml
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestEnvOverrides(t *testing.T) {
+	type Database struct {
+		URL           string `env:"DB_URL"`
+		ConnectionMax int
+		Timeout       time.Duration
+		Replicas      []string
+	}
+
+	type Server struct {
+		IP string
+	}
+
+	type Conf struct {
+		Name     string
+		Debug    *bool
+		Database Database
+		Servers  []Server
+		Labels   map[string]string
+		Token    *string
+		Enabled  *string
+	}
+
+	b := []byte(`
+	name = "app"
+
+	[database]
+	url = "postgres://localhost"
+	connection_max = 10
+
+	[[servers]]
+	ip = "10.0.0.1"
+
+	[production.database]
+	connection_max = 100
+	`)
+
+	t.Setenv("APP_NAME", "overridden")
+	t.Setenv("APP_DEBUG", "true")
+	t.Setenv("APP_DATABASE_CONNECTION_MAX", "200")
+	t.Setenv("APP_DATABASE_TIMEOUT", "5s")
+	t.Setenv("APP_DATABASE_REPLICAS", `["r1", "r2"]`)
+	t.Setenv("DB_URL", "postgres://db")
+	t.Setenv("APP_SERVERS_IP", "10.9.9.9")
+	t.Setenv("APP_LABELS", `{ team = "core" }`)
+	t.Setenv("APP_TOKEN", "12345")
+	t.Setenv("APP_ENABLED", "true")
+
+	c := &Conf{}
+	if err := NewDecoder(WithSource(Bytes(b)), WithEnvironment("production"), WithEnvOverrides("APP")).Decode(c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Name != "overridden" || c.Debug == nil || !*c.Debug || c.Labels["team"] != "core" {
+		t.Error(fmt.Sprintf("failed to override: %+v", c))
+	}
+	if c.Database.URL != "postgres://db" || c.Database.ConnectionMax != 200 || c.Database.Timeout != 5*time.Second || fmt.Sprint(c.Database.Replicas) != "[r1 r2]" {
+		t.Error(fmt.Sprintf("failed to override: %+v", c.Database))
+	}
+	if c.Servers[0].IP != "10.0.0.1" {
+		t.Error(fmt.Sprintf("failed to ignore array elements: %+v", c.Servers))
+	}
+	if c.Token == nil || *c.Token != "12345" || c.Enabled == nil || *c.Enabled != "true" {
+		t.Error(fmt.Sprintf("failed to override string pointers: %+v", c))
+	}
+
+	// not enabled
+	{
+		c := &Conf{}
+		if err := LoadBytes(c, b, "production"); err != nil {
+			t.Fatal(err)
+		}
+		if c.Name != "app" || c.Database.ConnectionMax != 100 {
+			t.Error(fmt.Sprintf("failed to load without overrides: %+v", c))
+		}
+	}
+
+	// invalid value
+	{
+		t.Setenv("APP_DATABASE_CONNECTION_MAX", "many")
+		c := &Conf{}
+		err := NewDecoder(WithSource(Bytes(b)), WithEnvOverrides("APP")).Decode(c)
+		var le *LoadError
+		if !errors.As(err, &le) || !errors.Is(err, ErrInvalidType) || le.Key != "database.connection_max" || le.Actual != "string" {
+			t.Errorf("expected invalid type: %v", err)
+		}
+	}
+}
+
+func TestEnvOverrides_unusedTable(t *testing.T) {
+	type Conf struct {
+		Database struct {
+			Owner string
+		}
+	}
+
+	b := []byte(`
+	[database]
+	owner = "admin"
+
+	[owner]
+	name = "ops"
+	`)
+
+	t.Setenv("APP_DATABASE_OWNER", "overridden")
+	c := &Conf{}
+	if err := NewDecoder(WithSource(Bytes(b)), WithEnvOverrides("APP")).Decode(c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Database.Owner != "overridden" {
+		t.Error(fmt.Sprintf("failed to override: %+v", c))
+	}
+}
+
+func TestEnvOverrides_pointerTable(t *testing.T) {
+	type TLS struct {
+		Cert string
+		Key  string
+	}
+	type Conf struct {
+		Name  string
+		TLS   *TLS
+		Proxy *TLS
+	}
+
+	b := []byte(`
+	name = "app"
+	`)
+
+	t.Setenv("APP_TLS_CERT", "cert.pem")
+	c := &Conf{}
+	if err := NewDecoder(WithSource(Bytes(b)), WithEnvOverrides("APP")).Decode(c); err != nil {
+		t.Fatal(err)
+	}
+	if c.TLS == nil || c.TLS.Cert != "cert.pem" || c.TLS.Key != "" {
+		t.Error(fmt.Sprintf("failed to override a missing pointer table: %+v", c.TLS))
+	}
+	if c.Proxy != nil {
+		t.Error(fmt.Sprintf("expected a missing pointer table without overrides to be nil: %+v", c.Proxy))
+	}
+}
+
+func TestEnvVarName(t *testing.T) {
+	for _, c := range []struct{ prefix, path, want string }{
+		{"APP", "database.connection_max", "APP_DATABASE_CONNECTION_MAX"},
+		{"", "servers.eu-west.ip", "SERVERS_EU_WEST_IP"},
+	} {
+		if got := envVarName(c.prefix, c.path); got != c.want {
+			t.Errorf("envVarName(%q, %q) = %q, want %q", c.prefix, c.path, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package toml
+
+import (
+	"fmt"
+	"github.com/pelletier/go-toml"
+	"sort"
+	"strings"
+)
+
+// use records that the setting at path is read by a field.
+func (s *decodeState) use(path string) {
+	s.used[s.normalize(path)] = true
+}
+
+// useAll records that the table or value at path is read as a whole, e.g. by a map.
+func (s *decodeState) useAll(path string) {
+	s.usedAll[s.normalize(path)] = true
+}
+
+// normalize returns path without environment sections and array indexes,
+// which is the path of the setting in the default settings.
+func (s *decodeState) normalize(path string) string {
+	var keys []string
+	for _, k := range splitKeys(path) {
+		// the index follows the closing quote of a quoted key
+		q := strings.LastIndex(k, `"`) + 1
+		if i := strings.Index(k[q:], "["); i >= 0 {
+			k = k[:q+i]
+		}
+		if k != "" && !s.envKeys[k] {
+			keys = append(keys, k)
+		}
+	}
+	return strings.Join(keys, ".")
+}
+
+// checkKeys reports the tables in tree that look like sections of an undeclared
+// environment and, in strict mode, the keys that are not read by any field.
+func (s *decodeState) checkKeys(tree *toml.TomlTree, prefix string) {
+	keys := tree.Keys()
+	sort.Strings(keys)
+	for _, k := range keys {
+		path := createPath(prefix, quoteKey(k))
+		norm := s.normalize(path)
+		switch {
+		case s.usedAll[norm], prefix == "" && k == environmentsKey, prefix == "" && k == environmentKey && s.environmentFrom:
+			continue
+		case s.envKeys[k]:
+			// environment section
+		case k == extendsKey && s.envKeys[lastKey(prefix)]:
+			continue
+		case !s.used[norm]:
+			err := ErrUnknownKey
+			if sub, ok := tree.GetPath([]string{k}).(*toml.TomlTree); ok && s.isEnvSection(sub, s.normalize(prefix)) {
+				err = ErrUnknownEnvironment
+			} else if !s.strict {
+				continue
+			}
+			e := &LoadError{Key: path, File: s.file, Err: err}
+			if pos := tree.GetPositionPath([]string{k}); !pos.Invalid() {
+				e.Line, e.Col = pos.Line, pos.Col
+			}
+			s.errs = append(s.errs, e)
+			continue
+		}
+		switch v := tree.GetPath([]string{k}).(type) {
+		case *toml.TomlTree:
+			s.checkKeys(v, path)
+		case []*toml.TomlTree:
+			for i, t := range v {
+				s.checkKeys(t, fmt.Sprintf("%s[%d]", path, i))
+			}
+		}
+	}
+}
+
+// isEnvSection reports whether every key of tree is a setting of the table at prefix.
+func (s *decodeState) isEnvSection(tree *toml.TomlTree, prefix string) bool {
+	keys := tree.Keys()
+	for _, k := range keys {
+		if p := createPath(prefix, quoteKey(k)); !s.used[p] && !s.usedAll[p] {
+			return false
+		}
+	}
+	return len(keys) > 0
+}
+
+func lastKey(path string) string {
+	return path[strings.LastIndex(path, ".")+1:]
+}
@@ -0,0 +1,121 @@
+package toml
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestStrict(t *testing.T) {
+	type Server struct {
+		IP string
+	}
+
+	type Postgres struct {
+		User     string
+		Password string
+	}
+
+	type Conf struct {
+		User          string
+		MaxConnection int
+		Labels        map[string]string
+		Servers       []Server
+		Postgres      Postgres
+	}
+
+	b := []byte(`user = "admin"
+max_conection = 100
+
+[development]
+user = "dev"
+
+[staging]
+extends = "production"
+
+[production]
+max_connection = 100
+
+[prodcution]
+user = "typo"
+
+[labels]
+team = "a"
+
+[labels.production]
+team = "b"
+
+[[servers]]
+ip = "10.0.0.1"
+port = 80
+
+[postgres]
+user = "root"
+
+[postgres.development]
+pasword = "12345"
+
+[postgres.prodcution]
+user = "typo"
+`)
+
+	examples := []struct {
+		Name string
+		Opts []Option
+		Keys []string
+	}{
+		{
+			Name: "not strict",
+			Opts: []Option{WithEnvironment("staging")},
+			Keys: nil,
+		},
+		{
+			Name: "strict",
+			Opts: []Option{WithEnvironment("staging"), WithStrict(), WithEnvironments("development", "staging", "production")},
+			Keys: []string{
+				"max_conection: unknown key",
+				"postgres.development.pasword: unknown key",
+				"postgres.prodcution: unknown environment",
+				"prodcution: unknown environment",
+				"servers[0].port: unknown key",
+			},
+		},
+		{
+			Name: "strict without declared environments",
+			Opts: []Option{WithEnvironment("staging"), WithStrict()},
+			Keys: []string{
+				"development: unknown environment",
+				"max_conection: unknown key",
+				"postgres.development: unknown key",
+				"postgres.prodcution: unknown environment",
+				"prodcution: unknown environment",
+				"servers[0].port: unknown key",
+			},
+		},
+	}
+
+	for _, example := range examples {
+		c := &Conf{}
+		err := NewDecoder(append(example.Opts, WithSource(Bytes(b)))...).Decode(c)
+		var keys []string
+		if err != nil {
+			errs, ok := err.(LoadErrors)
+			if !ok {
+				t.Fatalf("%s: expected LoadErrors: %v", example.Name, err)
+			}
+			for _, e := range errs {
+				keys = append(keys, fmt.Sprintf("%s: %v", e.Key, e.Err))
+			}
+		}
+		if fmt.Sprint(keys) != fmt.Sprint(example.Keys) {
+			t.Errorf("%s: unexpected errors: %v", example.Name, err)
+		}
+		if err != nil && (!errors.Is(err, ErrUnknownKey) || !errors.Is(err, ErrUnknownEnvironment)) {
+			t.Errorf("%s: unexpected errors: %v", example.Name, err)
+		}
+		if c.User != "admin" || c.MaxConnection != 100 || c.Labels["team"] != "b" || c.Postgres.User != "root" {
+			t.Error(fmt.Sprintf("%s: failed to load conf: %v", example.Name, c))
+		}
+		t.Log(err)
+	}
+}
@@ -1,23 +1,83 @@
 package toml
 
 import (
-	"errors"
 	"fmt"
 	"github.com/pelletier/go-toml"
 	"go/ast"
+	"io"
+	"io/fs"
 	"reflect"
+	"sort"
+	"strings"
 	"time"
 	"unicode"
 )
 
 var nilValue = reflect.ValueOf(nil)
 
+// emptyTree stands for a table that is not defined.
+var emptyTree, _ = toml.Load("")
+
+// Load reads the TOML file and sets the values for env into v.
 func Load(v interface{}, file string, env string) error {
-	tree, err := toml.LoadFile(file)
-	if err != nil {
-		return err
+	return NewDecoder(WithEnvironment(env), WithSource(File(file))).Decode(v)
+}
+
+// LoadBytes is like Load but reads the TOML document from b.
+func LoadBytes(v interface{}, b []byte, env string) error {
+	return NewDecoder(WithEnvironment(env), WithSource(Bytes(b))).Decode(v)
+}
+
+// LoadReader is like Load but reads the TOML document from r.
+func LoadReader(v interface{}, r io.Reader, env string) error {
+	return NewDecoder(WithEnvironment(env), WithSource(Reader(r))).Decode(v)
+}
+
+// LoadFiles is like Load but reads the TOML files in order, the later files overwriting the
+// earlier ones. The first file must exist and the others are skipped if they do not exist.
+// A file name containing "{env}" is read for env, see EnvFile.
+func LoadFiles(v interface{}, files []string, env string) error {
+	srcs := make([]Source, len(files))
+	for i, file := range files {
+		switch {
+		case strings.Contains(file, envPlaceholder):
+			srcs[i] = EnvFile(file)
+		case i == 0:
+			srcs[i] = File(file)
+		default:
+			srcs[i] = OptionalFile(file)
+		}
 	}
+	return NewDecoder(WithEnvironment(env), WithSources(srcs...)).Decode(v)
+}
+
+// LoadFS is like Load but reads the named TOML file from fsys.
+func LoadFS(v interface{}, fsys fs.FS, name string, env string) error {
+	return NewDecoder(WithEnvironment(env), WithSource(FS(fsys, name))).Decode(v)
+}
 
+type decodeState struct {
+	*Decoder
+	// envs is the lookup order of environments, most specific first.
+	envs []string
+	file string
+	// field is the struct field path of the current value.
+	field string
+	// merge is the merge strategy of the current field, see getArrayValue.
+	merge string
+	errs  LoadErrors
+	// envKeys are the keys of environment sections.
+	envKeys map[string]bool
+	// inferred are the top-level tables taken for environment sections, see inferEnvironments.
+	inferred map[string]bool
+	// used and usedAll are the normalized paths read by fields, see use and useAll.
+	used    map[string]bool
+	usedAll map[string]bool
+	// provenance is the origin of each field, recorded if metadata is requested.
+	provenance Provenance
+}
+
+func (d *Decoder) decode(v interface{}, docs []document) error {
 	if v == nil {
 		return fmt.Errorf("v must not be nil")
 	}
@@ -26,51 +86,249 @@ func Load(v interface{}, file string, env string) error {
 		return fmt.Errorf("v must be a struct pointer")
 	}
 
-	rv = rv.Elem()
-	rt := rv.Type()
+	if len(docs) == 0 {
+		// every source is optional and missing
+		docs = []document{{tree: emptyTree}}
+	}
+	tree := mergeTrees(docs)
+	overlays, source, err := d.activeOverlays(tree)
+	if err != nil {
+		return err
+	}
+	if d.metadata != nil {
+		*d.metadata = Metadata{EnvironmentSource: source}
+		for _, doc := range docs {
+			d.metadata.Files = append(d.metadata.Files, doc.file)
+		}
+		if len(overlays) > 0 {
+			d.metadata.Environment = overlays[len(overlays)-1]
+		}
+	}
+	envs, err := d.lookupOrder(tree, overlays)
+	if err != nil {
+		return err
+	}
+	declared, err := d.declaredEnvironments(tree)
+	if err != nil {
+		return err
+	}
+	if err := checkEnvironments(envs, declared); err != nil {
+		return err
+	}
+	var file string
+	if len(docs) == 1 {
+		file = docs[0].file
+	}
+	s := d.newDecodeState(envs, file)
+	s.addEnvKeys(declared...)
+	if d.metadata != nil {
+		s.provenance = Provenance{}
+	}
+	if !d.strict && len(declared) == 0 {
+		s.inferEnvironments(tree, rv.Elem().Type())
+	}
+	err = s.setStructFields(rv.Elem(), s.documentLayers(docs))
+	if d.metadata != nil {
+		d.metadata.Provenance = s.provenance
+	}
+	if err != nil {
+		if len(s.errs) == 0 {
+			return err
+		}
+		// keep the missing required keys found before err
+		le, ok := err.(*LoadError)
+		if !ok {
+			le = &LoadError{File: s.file, Err: err}
+		}
+		return append(s.errs, le)
+	}
+	if d.strict || len(declared) > 0 {
+		for _, doc := range docs {
+			s.file = doc.file
+			s.checkKeys(doc.tree, "")
+		}
+	}
+	if len(s.errs) > 0 {
+		return s.errs
+	}
+	return nil
+}
 
-	for i := 0; i < rt.NumField(); i++ {
-		ft := rt.Field(i)
-		fv := rv.Field(i)
-		if !ast.IsExported(ft.Name) {
+func (d *Decoder) newDecodeState(envs []string, file string) *decodeState {
+	s := &decodeState{
+		Decoder:  d,
+		envs:     envs,
+		file:     file,
+		envKeys:  map[string]bool{},
+		inferred: map[string]bool{},
+		used:     map[string]bool{},
+		usedAll:  map[string]bool{},
+	}
+	s.addEnvKeys(envs...)
+	s.addEnvKeys(d.environments...)
+	for env, parent := range d.parents {
+		s.addEnvKeys(env, parent)
+	}
+	return s
+}
+
+func (s *decodeState) addEnvKeys(envs ...string) {
+	for _, env := range envs {
+		for _, k := range strings.Split(env, ".") {
+			if k != "" {
+				s.envKeys[k] = true
+			}
+		}
+	}
+}
+
+// inferEnvironments takes the top-level tables that no field of t reads, and whose keys are
+// all read by fields of t, for environment sections. They are only used to tell the
+// environment sections of maps from their entries, and never change key paths.
+func (s *decodeState) inferEnvironments(tree *toml.TomlTree, t reflect.Type) {
+	fields := map[string]bool{}
+	s.fieldNames(t, fields)
+	for _, k := range tree.Keys() {
+		table, ok := tree.GetPath([]string{k}).(*toml.TomlTree)
+		if !ok || fields[k] || k == environmentsKey {
 			continue
 		}
-		name := getFieldName(ft)
-		value, err := getValue(fv.Type(), tree, name, env)
+		s.inferred[k] = true
+		for _, sub := range table.Keys() {
+			if !fields[sub] && sub != extendsKey {
+				delete(s.inferred, k)
+				break
+			}
+		}
+	}
+}
+
+// fieldNames adds the keys read by the fields of the struct type t to names.
+func (s *decodeState) fieldNames(t reflect.Type, names map[string]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		ft := t.Field(i)
+		tag := s.getFieldTag(ft)
+		switch {
+		case tag.skip:
+		case tag.inline:
+			s.fieldNames(indirect(ft.Type), names)
+		default:
+			names[tag.name] = true
+		}
+	}
+}
+
+// report records err if all errors are collected and reports whether decoding can go on.
+func (s *decodeState) report(err error) bool {
+	if !s.allErrors {
+		return false
+	}
+	le, ok := err.(*LoadError)
+	if !ok {
+		le = &LoadError{Field: s.field, File: s.file, Err: err}
+	}
+	s.errs = append(s.errs, le)
+	return true
+}
+
+func (s *decodeState) restore(field string) {
+	s.field = field
+}
+
+// newError returns a LoadError for the value f.
+func (s *decodeState) newError(f found, err error) *LoadError {
+	o := s.origin(f)
+	return &LoadError{Field: s.field, Key: o.Key, Env: o.Env, File: o.File, Line: o.Line, Col: o.Col, Err: err}
+}
+
+// newTypeError returns a LoadError for the value f which is v and cannot be set to t.
+func (s *decodeState) newTypeError(f found, t reflect.Type, v interface{}, err error) *LoadError {
+	e := s.newError(f, err)
+	e.Expected = t.String()
+	e.Actual = tomlType(v)
+	return e
+}
+
+// notFound returns a LoadError for elem which is not in ls.
+// The error points at the least specific layer, usually the default settings.
+func (s *decodeState) notFound(ls layers, elem string) *LoadError {
+	e := s.newError(found{layer: ls[len(ls)-1]}, ErrNotFound)
+	e.Key = createPath(ls[len(ls)-1].key, elem)
+	return e
+}
+
+func (s *decodeState) applyHooks(t reflect.Type, v interface{}) (interface{}, error) {
+	for _, hook := range s.hooks {
+		var err error
+		if v, err = hook(t, v); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+func basicValue(t reflect.Type, v interface{}) (reflect.Value, error) {
+	vt := reflect.TypeOf(v)
+	switch {
+	case vt == nil:
+		return nilValue, ErrInvalidType
+	case vt == t, t.Kind() == reflect.Interface && vt.AssignableTo(t):
+		return reflect.ValueOf(v), nil
+	case t.Kind() == reflect.Ptr:
+		ev, err := basicValue(t.Elem(), v)
 		if err != nil {
-			return err
+			return nilValue, err
 		}
-		fv.Set(value)
+		rv := reflect.New(t.Elem())
+		rv.Elem().Set(ev)
+		return rv, nil
 	}
-	return nil
+	switch vt.Kind() {
+	case reflect.String, reflect.Bool:
+		// named types such as `type Region string`
+		if t.Kind() == vt.Kind() {
+			return reflect.ValueOf(v).Convert(t), nil
+		}
+	case reflect.Int64:
+		switch t.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uintptr, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return castValue(t, reflect.ValueOf(v))
+		}
+	case reflect.Float64:
+		switch t.Kind() {
+		case reflect.Float32, reflect.Float64:
+			return castValue(t, reflect.ValueOf(v))
+		}
+	}
+	return nilValue, ErrInvalidType
 }
 
 func castValue(t reflect.Type, v reflect.Value) (reflect.Value, error) {
+	if v.Type() == t {
+		return v, nil
+	}
 	switch t.Kind() {
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		n := v.Interface().(int64)
+		n := v.Int()
 		rv := reflect.New(t).Elem()
 		if rv.OverflowInt(n) {
-			return nilValue, errors.New(fmt.Sprintf("%s is overflow: %v", t.Name, v))
+			return nilValue, fmt.Errorf("%w: %v does not fit %s", ErrOverflow, n, t)
 		}
 		rv.SetInt(n)
 		return rv, nil
 	case reflect.Uint, reflect.Uintptr, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		n := v.Interface().(int64)
+		n := v.Int()
 		rv := reflect.New(t).Elem()
-		if n < 0 {
-			return nilValue, errors.New(fmt.Sprintf("%s is overflow: %v", t.Name, v))
-		}
-		if rv.OverflowUint(uint64(n)) {
-			return nilValue, errors.New(fmt.Sprintf("%s is overflow: %v", t.Name, v))
+		if n < 0 || rv.OverflowUint(uint64(n)) {
+			return nilValue, fmt.Errorf("%w: %v does not fit %s", ErrOverflow, n, t)
 		}
 		rv.SetUint(uint64(n))
 		return rv, nil
 	case reflect.Float32, reflect.Float64:
-		n := v.Interface().(float64)
+		n := v.Float()
 		rv := reflect.New(t).Elem()
 		if rv.OverflowFloat(n) {
-			return nilValue, errors.New(fmt.Sprintf("%s is overflow: %v", t.Name, v))
+			return nilValue, fmt.Errorf("%w: %v does not fit %s", ErrOverflow, n, t)
 		}
 		rv.SetFloat(n)
 		return rv, nil
@@ -79,159 +337,581 @@ func castValue(t reflect.Type, v reflect.Value) (reflect.Value, error) {
 	}
 }
 
-func getValue(t reflect.Type, tree *toml.TomlTree, elem, env string) (reflect.Value, error) {
+func (s *decodeState) getValue(t reflect.Type, ls layers, elem string) (reflect.Value, error) {
+	merge := s.merge
+	s.merge = ""
 	switch {
-	case t == reflect.TypeOf(time.Time{}):
-		return getBasicValue(t, tree, elem, env)
+	case isUnmarshaler(t):
+		// including time.Time
+		return s.getBasicValue(t, ls, elem)
+	case t.Kind() == reflect.Ptr:
+		s.merge = merge
+		return s.getPtrValue(t, ls, elem)
 	case t.Kind() == reflect.Struct:
-		return getStructValue(t, tree, elem, env)
+		return s.getStructValue(t, ls, elem)
 	case t.Kind() == reflect.Map:
-		return getMapValue(t, tree, elem, env)
+		return s.getMapValue(t, ls, elem)
 	case t.Kind() == reflect.Array, t.Kind() == reflect.Slice:
-		return getArrayValue(t, tree, elem, env)
+		return s.getArrayValue(t, ls, elem, merge)
 	default:
-		v, e := getBasicValue(t, tree, elem, env)
-		if e == nil {
-			return castValue(t, v)
-		}
-		return v, e
+		return s.getBasicValue(t, ls, elem)
 	}
 }
 
-func getBasicValue(t reflect.Type, tree *toml.TomlTree, elem, env string) (reflect.Value, error) {
-	p, err := findPath(tree, elem, env)
+// getPtrValue returns a pointer to the value of elem.
+// Pointer fields are nil if their key is not defined, see getMissingValue.
+func (s *decodeState) getPtrValue(t reflect.Type, ls layers, elem string) (reflect.Value, error) {
+	v, err := s.getValue(t.Elem(), ls, elem)
 	if err != nil {
 		return nilValue, err
 	}
-	v := tree.Get(p)
-	vt := reflect.TypeOf(v)
-	if vt.Kind() == reflect.Int64 {
-		switch t.Kind() {
-		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uintptr, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-			//nop
-		default:
-			return nilValue, errors.New(fmt.Sprint("invalid type:", t, vt))
-		}
-	} else if vt.Kind() == reflect.Float64 {
-		switch t.Kind() {
-		case reflect.Float32, reflect.Float64:
-			//nop
-		default:
-			return nilValue, errors.New(fmt.Sprint("invalid type:", t, vt))
-		}
-	} else if reflect.TypeOf(v) != t {
-		return nilValue, errors.New("invalid type")
+	rv := reflect.New(t.Elem())
+	rv.Elem().Set(v)
+	return rv, nil
+}
+
+func (s *decodeState) getBasicValue(t reflect.Type, ls layers, elem string) (reflect.Value, error) {
+	f, err := s.find(ls, elem)
+	if err != nil {
+		return nilValue, err
+	}
+	raw := f.get()
+	if _, ok := raw.(*toml.TomlTree); ok {
+		s.useAll(f.key())
+	}
+	v, err := s.applyHooks(t, raw)
+	if err != nil {
+		return nilValue, s.newError(f, err)
+	}
+	rv, err := s.convertValue(t, v)
+	if err != nil {
+		return nilValue, s.newTypeError(f, t, v, err)
 	}
-	return reflect.ValueOf(v), nil
+	return rv, nil
 }
 
-func getArrayValue(t reflect.Type, tree *toml.TomlTree, elem, env string) (reflect.Value, error) {
-	if t.Kind() != reflect.Array && t.Kind() != reflect.Slice {
-		return nilValue, errors.New("invalid type")
+// getArrayValue returns the array of elem merged across environments by the strategy
+// given by the merge tag of the field:
+//
+//	replace  the most specific array (default)
+//	append   the arrays from the least specific one
+//	prepend  the arrays from the most specific one
+//	union    like append, without duplicated elements
+//	by=name  the elements of arrays of tables, where elements with the same name
+//	         are merged into one element overwriting the fields it defines
+func (s *decodeState) getArrayValue(t reflect.Type, ls layers, elem, merge string) (reflect.Value, error) {
+	fs := s.findAll(ls, elem)
+	if len(fs) == 0 {
+		return nilValue, s.notFound(ls, elem)
+	}
+	var elems []reflect.Value
+	var err error
+	switch {
+	case merge == "", merge == "replace":
+		elems, err = s.getArrayElems(t.Elem(), fs[0])
+	case merge == "append", merge == "prepend", merge == "union":
+		// merge from the least specific array
+		for i := len(fs) - 1; i >= 0; i-- {
+			vs, err := s.getArrayElems(t.Elem(), fs[i])
+			if err != nil {
+				return nilValue, err
+			}
+			if merge == "prepend" {
+				elems = append(vs, elems...)
+			} else {
+				elems = append(elems, vs...)
+			}
+		}
+		if merge == "union" {
+			elems = uniqueValues(elems)
+		}
+	case strings.HasPrefix(merge, "by="):
+		elems, err = s.getArrayElemsByKey(t.Elem(), fs, strings.TrimPrefix(merge, "by="))
+	default:
+		err = s.newError(fs[0], fmt.Errorf("unknown merge strategy %q", merge))
 	}
-	p, err := findPath(tree, elem, env)
 	if err != nil {
 		return nilValue, err
 	}
-	v := tree.Get(p)
-	et := t.Elem()
-	rv := reflect.MakeSlice(t, 0, 0)
+	return s.makeArray(t, fs[0], elems)
+}
+
+// getArrayElems returns the elements of the array f.
+func (s *decodeState) getArrayElems(et reflect.Type, f found) ([]reflect.Value, error) {
+	return s.arrayElems(et, f, f.key(), f.get())
+}
 
-	switch ary := v.(type) {
+// arrayElems returns the elements of ary, the array at key in f or nested in it.
+func (s *decodeState) arrayElems(et reflect.Type, f found, key string, ary interface{}) ([]reflect.Value, error) {
+	defer s.restore(s.field)
+	field := s.field
+	var raws []interface{}
+
+	switch ary := ary.(type) {
 	case []*toml.TomlTree:
-		for _, childTree := range ary {
-			ev, e := getValue(et, childTree, "", elem)
-			if e != nil {
-				return nilValue, e
-			}
-			rv = reflect.Append(rv, ev)
+		for _, tree := range ary {
+			raws = append(raws, tree)
 		}
 	case []interface{}:
-		for _, a := range ary {
-			av, e := castValue(et, reflect.ValueOf(a))
-			if e != nil {
-				return nilValue, errors.New("Invalid type")
+		raws = ary
+	default:
+		return nil, s.newTypeError(f, reflect.SliceOf(et), ary, ErrInvalidType)
+	}
+
+	var elems []reflect.Value
+	for i, a := range raws {
+		s.field = fmt.Sprintf("%s[%d]", field, i)
+		ev, err := s.elemValue(et, f, fmt.Sprintf("%s[%d]", key, i), a)
+		if err != nil {
+			if s.report(err) {
+				continue
 			}
-			rv = reflect.Append(rv, av)
+			return nil, err
 		}
-	default:
-		return nilValue, errors.New("Invalid type")
+		elems = append(elems, ev)
+	}
+	return elems, nil
+}
+
+// elemValue returns the element v at key of an array in f as a value of type et.
+func (s *decodeState) elemValue(et reflect.Type, f found, key string, v interface{}) (reflect.Value, error) {
+	if et.Kind() == reflect.Ptr {
+		ev, err := s.elemValue(et.Elem(), f, key, v)
+		if err != nil {
+			return nilValue, err
+		}
+		rv := reflect.New(et.Elem())
+		rv.Elem().Set(ev)
+		return rv, nil
+	}
+	if !isUnmarshaler(et) && et.Kind() != reflect.Interface {
+		switch v := v.(type) {
+		case *toml.TomlTree:
+			// each table can have its own environment sections
+			child := layer{tree: v, key: key, env: f.env, level: f.level, file: f.layer.file}
+			return s.getValue(et, layers{child}, "")
+		case []*toml.TomlTree, []interface{}:
+			if et.Kind() == reflect.Array || et.Kind() == reflect.Slice {
+				elems, err := s.arrayElems(et.Elem(), f, key, v)
+				if err != nil {
+					return nilValue, err
+				}
+				return s.makeArray(et, f, elems)
+			}
+		}
+	}
+	v, err := s.applyHooks(et, v)
+	if err != nil {
+		e := s.newError(f, err)
+		e.Key = key
+		return nilValue, e
+	}
+	ev, err := s.convertValue(et, v)
+	if err != nil {
+		e := s.newTypeError(f, et, v, err)
+		e.Key = key
+		return nilValue, e
+	}
+	return ev, nil
+}
+
+// makeArray returns elems as a value of the slice or array type t.
+// An array must have as many elements as its length.
+func (s *decodeState) makeArray(t reflect.Type, f found, elems []reflect.Value) (reflect.Value, error) {
+	if t.Kind() == reflect.Slice {
+		rv := reflect.MakeSlice(t, 0, len(elems))
+		return reflect.Append(rv, elems...), nil
+	}
+	if len(elems) != t.Len() {
+		return nilValue, s.newError(f, fmt.Errorf("%w: %d elements do not fit %s", ErrInvalidType, len(elems), t))
+	}
+	rv := reflect.New(t).Elem()
+	for i, ev := range elems {
+		rv.Index(i).Set(ev)
 	}
 	return rv, nil
 }
 
-func getMapValue(t reflect.Type, tree *toml.TomlTree, elem, env string) (reflect.Value, error) {
-	if t.Kind() != reflect.Map || t.Key().Kind() != reflect.String {
-		return nilValue, errors.New("invalid type")
+// getArrayElemsByKey returns the elements of the arrays of tables fs, where the tables
+// with the same value of key are merged, the more specific one overwriting the other.
+func (s *decodeState) getArrayElemsByKey(et reflect.Type, fs []found, key string) ([]reflect.Value, error) {
+	defer s.restore(s.field)
+	field := s.field
+	var names []interface{}
+	tables := map[interface{}]layers{}
+
+	// collect the tables from the least specific array
+	for i := len(fs) - 1; i >= 0; i-- {
+		f := fs[i]
+		ary, ok := f.get().([]*toml.TomlTree)
+		if !ok {
+			return nil, s.newTypeError(f, reflect.SliceOf(et), f.get(), ErrInvalidType)
+		}
+		for j, tree := range ary {
+			table := layer{tree: tree, key: fmt.Sprintf("%s[%d]", f.key(), j), env: f.env, level: f.level, file: f.layer.file}
+			name := tree.Get(key)
+			if name == nil {
+				// tables without a name are never merged
+				name = &table
+			}
+			if _, ok := tables[name]; !ok {
+				names = append(names, name)
+			}
+			tables[name] = append(layers{table}, tables[name]...)
+		}
 	}
-	target := tree
-	// find env if elem defined
-	if elem != "" {
-		p, err := findPath(tree, elem, env)
+
+	var elems []reflect.Value
+	for i, name := range names {
+		s.field = fmt.Sprintf("%s[%d]", field, i)
+		ev, err := s.getValue(et, tables[name], "")
 		if err != nil {
-			return nilValue, err
+			if s.report(err) {
+				continue
+			}
+			return nil, err
 		}
-		newTree := tree.Get(p)
-		if val, ok := newTree.(*toml.TomlTree); ok {
-			target = val
-		} else {
-			return nilValue, errors.New("invalud tree")
+		elems = append(elems, ev)
+	}
+	return elems, nil
+}
+
+// uniqueValues returns vs without the values equal to a former one.
+func uniqueValues(vs []reflect.Value) []reflect.Value {
+	var unique []reflect.Value
+	for _, v := range vs {
+		found := false
+		for _, u := range unique {
+			if reflect.DeepEqual(u.Interface(), v.Interface()) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			unique = append(unique, v)
+		}
+	}
+	return unique
+}
+
+// getTable returns the tables of elem, or ls itself if elem is empty.
+// With deep merge, these are the tables of every environment, otherwise
+// the tables of the most specific environment that defines elem.
+func (s *decodeState) getTable(t reflect.Type, ls layers, elem string) (layers, error) {
+	if elem == "" {
+		return ls, nil
+	}
+	fs := s.findAll(ls, elem)
+	if len(fs) == 0 {
+		return nil, s.notFound(ls, elem)
+	}
+	var tables layers
+	for _, f := range fs {
+		if !s.deepMerge && f.level != fs[0].level {
+			break
+		}
+		table, ok := f.table()
+		if !ok {
+			return nil, s.newTypeError(f, t, f.get(), ErrInvalidType)
 		}
+		tables = append(tables, table)
+	}
+	return tables, nil
+}
+
+func (s *decodeState) getMapValue(t reflect.Type, ls layers, elem string) (reflect.Value, error) {
+	defer s.restore(s.field)
+	field := s.field
+	tables, err := s.getTable(t, ls, elem)
+	if err != nil {
+		return nilValue, err
+	}
+	s.useAll(tables[0].key)
+	keys, err := s.mapKeys(tables)
+	if err != nil {
+		return nilValue, err
 	}
 	// get map value from tree
 	rv := reflect.MakeMap(t)
-	for _, k := range target.Keys() {
-		v, err := getValue(t.Elem(), target, k, env)
+	for _, k := range keys {
+		s.field = fmt.Sprintf("%s[%s]", field, k)
+		// keys are looked up by their components, so keys can contain dots
+		elem := quoteKey(k)
+		kv, err := s.keyValue(t.Key(), tables, k, elem)
 		if err != nil {
-			continue
+			if s.report(err) {
+				continue
+			}
+			return nilValue, err
 		}
-		rv.SetMapIndex(reflect.ValueOf(k), v)
+		v, err := s.getValue(t.Elem(), tables, elem)
+		if err != nil {
+			if s.report(err) {
+				continue
+			}
+			return nilValue, err
+		}
+		rv.SetMapIndex(kv, v)
 	}
 	return rv, nil
 }
 
-func getStructValue(t reflect.Type, tree *toml.TomlTree, elem, env string) (reflect.Value, error) {
-	if t.Kind() != reflect.Struct {
-		return nilValue, errors.New("invalid type")
+// keyValue returns the key k at elem of the map in ls as a value of type t.
+// Keys are converted like default values, so `[shards] 1 = ...` is a key of map[int]Shard.
+func (s *decodeState) keyValue(t reflect.Type, ls layers, k, elem string) (reflect.Value, error) {
+	var v interface{} = k
+	if !isUnmarshaler(t) && !strings.ContainsAny(k, "\r\n") {
+		v = defaultTree(t, k).Get(defaultKey)
 	}
-	target := tree
-	// find env if elem defined
-	if elem != "" {
-		p, err := findPath(tree, elem, env)
-		if err != nil {
-			return nilValue, err
+	kv, err := s.convertValue(t, v)
+	if err != nil {
+		f, _ := s.find(ls, elem)
+		return nilValue, s.newTypeError(f, t, v, err)
+	}
+	return kv, nil
+}
+
+// deleteKey is the key in an environment section of a map listing the keys to delete.
+const deleteKey = "_delete"
+
+// mapKeys returns the keys of the map in ls: the keys of the default settings and the keys
+// added by environment sections, except environment sections and keys deleted by them.
+// Only tables are environment sections, so a value can be keyed by an environment name.
+func (s *decodeState) mapKeys(ls layers) ([]string, error) {
+	keys := map[string]bool{}
+	for _, section := range s.sections(ls) {
+		for _, k := range section.tree.Keys() {
+			if k == deleteKey {
+				continue
+			}
+			if _, ok := section.tree.GetPath([]string{k}).(*toml.TomlTree); ok && (s.envKeys[k] || s.inferred[k]) {
+				continue
+			}
+			keys[k] = true
 		}
-		newTree := tree.Get(p)
-		if val, ok := newTree.(*toml.TomlTree); ok {
-			target = val
-		} else {
-			return nilValue, errors.New("invalid tree")
+		v := section.tree.Get(deleteKey)
+		if v == nil || section.level == len(s.envs) {
+			continue
+		}
+		f := found{layer: section, path: deleteKey, env: section.env, level: section.level}
+		deleted, ok := v.([]interface{})
+		if !ok {
+			return nil, s.newTypeError(f, reflect.TypeOf([]string{}), v, ErrInvalidType)
 		}
+		for _, d := range deleted {
+			k, ok := d.(string)
+			if !ok {
+				return nil, s.newTypeError(f, reflect.TypeOf(""), d, ErrInvalidType)
+			}
+			delete(keys, k)
+		}
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+	return sorted, nil
+}
+
+func (s *decodeState) getStructValue(t reflect.Type, ls layers, elem string) (reflect.Value, error) {
+	tables, err := s.getTable(t, ls, elem)
+	if err != nil {
+		return nilValue, err
 	}
-	// get struct value from tree
 	rv := reflect.New(t).Elem()
-	for i := 0; i < t.NumField(); i++ {
-		fv := rv.Field(i)
-		ft := t.Field(i)
-		if !ast.IsExported(ft.Name) {
+	if err := s.setStructFields(rv, tables); err != nil {
+		return nilValue, err
+	}
+	return rv, nil
+}
+
+// setStructFields sets the exported fields of rv from ls.
+func (s *decodeState) setStructFields(rv reflect.Value, ls layers) error {
+	defer s.restore(s.field)
+	field := s.field
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		ft := rt.Field(i)
+		tag := s.getFieldTag(ft)
+		// the exported fields of an embedded struct are promoted even if its type is not exported
+		if tag.skip || !ast.IsExported(ft.Name) && !(tag.inline && ft.Type.Kind() == reflect.Struct) {
+			continue
+		}
+		s.field = createPath(field, ft.Name)
+		if tag.inline {
+			if err := s.setInlineFields(rv.Field(i), ls); err != nil {
+				return err
+			}
 			continue
 		}
-		name := getFieldName(ft)
-		value, err := getValue(ft.Type, target, name, env)
+		s.use(createPath(ls[0].key, tag.name))
+		s.merge = tag.merge
+		key := s.normalize(createPath(ls[0].key, tag.name))
+		var value reflect.Value
+		var err error
+		if name, flag, ok := s.lookupFlag(ls, tag); ok {
+			s.record(Origin{Source: OriginFlag, Key: key, Name: "-" + name})
+			value, err = s.getLiteralValue(ft.Type, ls, tag, flag, "flag -"+name)
+		} else if name, env, ok := s.lookupEnvVar(ls, tag); ok {
+			s.record(Origin{Source: OriginEnvVar, Key: key, Name: name})
+			value, err = s.getLiteralValue(ft.Type, ls, tag, env, "environment variable "+name)
+		} else if fs := s.findAll(ls, tag.name); len(fs) == 0 {
+			if tag.hasDefault {
+				s.record(Origin{Source: OriginDefault, Key: key})
+			} else {
+				s.record(Origin{Source: OriginUnset, Key: key})
+			}
+			value, err = s.getMissingValue(ft.Type, ls, tag)
+		} else {
+			s.record(s.origin(fs[0]))
+			value, err = s.getValue(ft.Type, ls, tag.name)
+		}
+		if err != nil {
+			if s.report(err) {
+				continue
+			}
+			return err
+		}
+		rv.Field(i).Set(value)
+	}
+	return nil
+}
+
+// setInlineFields sets the fields of the embedded or inline struct rv from the tables of its parent.
+func (s *decodeState) setInlineFields(rv reflect.Value, ls layers) error {
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			rv.Set(reflect.New(rv.Type().Elem()))
+		}
+		rv = rv.Elem()
+	}
+	return s.setStructFields(rv, ls)
+}
+
+// getMissingValue returns the value of a field whose key is not defined.
+// A required field is reported as missing, and the fields of a missing table
+// are checked as if the table were empty. A pointer field is left nil
+// unless an override sets one of the fields it points to.
+func (s *decodeState) getMissingValue(t reflect.Type, ls layers, tag fieldTag) (reflect.Value, error) {
+	if t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Struct && !isUnmarshaler(t.Elem()) &&
+		s.isOverridden(t.Elem(), s.normalize(createPath(ls[0].key, tag.name)), map[reflect.Type]bool{}) {
+		ev, err := s.getMissingValue(t.Elem(), ls, fieldTag{name: tag.name})
 		if err != nil {
 			return nilValue, err
 		}
-		fv.Set(value)
+		rv := reflect.New(t.Elem())
+		rv.Elem().Set(ev)
+		return rv, nil
 	}
-	return rv, nil
+	if tag.required {
+		s.errs = append(s.errs, s.notFound(ls, tag.name))
+		return reflect.Zero(t), nil
+	}
+	if tag.hasDefault {
+		return s.getDefaultValue(t, ls, tag)
+	}
+	if t.Kind() == reflect.Struct && t != reflect.TypeOf(time.Time{}) {
+		empty := layer{tree: emptyTree, key: createPath(ls[len(ls)-1].key, tag.name), level: len(s.envs), file: ls[len(ls)-1].file}
+		return s.getStructValue(t, layers{empty}, "")
+	}
+	return reflect.Zero(t), nil
+}
+
+// defaultKey is the key of the default value in the table made by defaultTree.
+const defaultKey = "default"
+
+// getDefaultValue converts the default value of a field like a value in the TOML document.
+func (s *decodeState) getDefaultValue(t reflect.Type, ls layers, tag fieldTag) (reflect.Value, error) {
+	return s.getLiteralValue(t, ls, tag, tag.defaultValue, fmt.Sprintf("default %q", tag.defaultValue))
+}
+
+// getLiteralValue converts str, a value of a field given outside the TOML document,
+// like a value in the TOML document. Errors are prefixed by desc.
+func (s *decodeState) getLiteralValue(t reflect.Type, ls layers, tag fieldTag, str, desc string) (reflect.Value, error) {
+	def := layer{tree: defaultTree(t, str), level: len(s.envs)}
+	v, err := s.getValue(t, layers{def}, defaultKey)
+	if err != nil {
+		e := &LoadError{Field: s.field, Key: createPath(ls[len(ls)-1].key, tag.name), Err: err}
+		if le, ok := err.(*LoadError); ok {
+			e.Expected, e.Actual, e.Err = le.Expected, le.Actual, le.Err
+		}
+		e.Err = fmt.Errorf("%s: %w", desc, e.Err)
+		return nilValue, e
+	}
+	return v, nil
 }
 
-func getFieldName(f reflect.StructField) string {
-	name := f.Tag.Get("toml")
-	if name == "" {
-		name = toSnake(f.Name)
+// defaultTree returns a table holding def as a TOML value, or as a string if
+// t is a string or a pointer to one, or def is not a TOML value.
+func defaultTree(t reflect.Type, def string) *toml.TomlTree {
+	if indirect(t).Kind() != reflect.String {
+		if tree, err := toml.Load(defaultKey + " = " + def); err == nil {
+			return tree
+		}
 	}
-	return name
+	tree, _ := toml.Load("")
+	tree.Set(defaultKey, def)
+	return tree
+}
+
+// fieldTag is the parsed struct tag of a field, e.g. `toml:"port,required"` or `toml:"port,default=8080"`.
+// The default value can also be given by a `default:"8080"` tag.
+type fieldTag struct {
+	name     string
+	required bool
+	// skip is set by `toml:"-"`.
+	skip bool
+	// inline is set for embedded structs without a name and by `toml:",inline"`;
+	// the fields of an inline struct are read from the table of its parent.
+	inline       bool
+	hasDefault   bool
+	defaultValue string
+	// merge is the strategy to merge arrays given by a `merge:"append"` tag.
+	merge string
+	// envVar is the environment variable overriding the field given by an `env:"DB_URL"` tag.
+	envVar string
+}
+
+func (s *decodeState) getFieldTag(f reflect.StructField) fieldTag {
+	tag := fieldTag{merge: f.Tag.Get("merge"), envVar: f.Tag.Get("env")}
+	tag.defaultValue, tag.hasDefault = f.Tag.Lookup("default")
+	value := f.Tag.Get(s.tagName)
+	// the default value is the rest of the tag and may contain commas
+	if i := strings.Index(value, ",default="); i >= 0 {
+		tag.defaultValue, tag.hasDefault = value[i+len(",default="):], true
+		value = value[:i]
+	}
+	if value == "-" {
+		tag.skip = true
+		return tag
+	}
+	opts := strings.Split(value, ",")
+	tag.name = opts[0]
+	if tag.name == "" {
+		tag.name = s.naming(f.Name)
+		tag.inline = f.Anonymous
+	}
+	for _, opt := range opts[1:] {
+		switch opt {
+		case "required":
+			tag.required = true
+		case "inline":
+			tag.inline = true
+		}
+	}
+	if t := indirect(f.Type); t.Kind() != reflect.Struct || isUnmarshaler(t) {
+		tag.inline = false
+	}
+	return tag
+}
+
+// indirect returns the type t points to, or t itself if it is not a pointer.
+func indirect(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Ptr {
+		return t.Elem()
+	}
+	return t
 }
 
 func toSnake(in string) string {
@@ -262,15 +942,3 @@ func createPath(in ...string) string {
 	}
 	return out
 }
-
-func findPath(tree *toml.TomlTree, elem, env string) (string, error) {
-	envPath := createPath(env, elem)
-	elemPath := createPath(elem)
-	if tree.Has(envPath) {
-		return envPath, nil
-	} else if tree.Has(elemPath) {
-		return elemPath, nil
-	} else {
-		return "", errors.New("path not found")
-	}
-}
@@ -259,5 +259,5 @@ func ExampleLoad_example3development() {
 	fmt.Printf("%+v", c)
 
 	// Output:
-	// &{Title:TOML Example Owner:{Name:Lance Uppercut Dob:1979-05-27 07:32:00 -0800 -0800} Database:{Server:192.168.1.1 Ports:[8001 8001 8002] ConnectionMax:5000 Enabled:true} Servers:map[beta:{IP:10.0.0.2 DC:eqdc10} alpha:{IP:10.0.0.1 DC:eqdc10}] Clients:{Data:[[gamma delta] [1 2]] Hosts:[alpha omega]}}
+	// &{Title:TOML Example Owner:{Name:Lance Uppercut Dob:1979-05-27 07:32:00 -0800 -0800} Database:{Server:192.168.1.1 Ports:[8001 8001 8002] ConnectionMax:5000 Enabled:true} Servers:map[alpha:{IP:10.0.0.1 DC:eqdc10} beta:{IP:10.0.0.2 DC:eqdc10}] Clients:{Data:[[gamma delta] [1 2]] Hosts:[alpha omega]}}
 }
@@ -1,12 +1,16 @@
 package toml
 
 import (
+	"errors"
 	"fmt"
 	"github.com/pelletier/go-toml"
 	"log"
 	"math"
+	"os"
 	"reflect"
+	"strings"
 	"testing"
+	"testing/fstest"
 	"time"
 )
 
@@ -60,6 +64,88 @@ func TestLoad(t *testing.T) {
 	}
 }
 
+func TestLoadBytes(t *testing.T) {
+	type Conf struct {
+		User string
+		Age  int
+	}
+
+	b := []byte(`
+	user = "admin"
+	age = 10
+	[development]
+	user = "root"
+	`)
+
+	c := &Conf{}
+	if err := LoadBytes(c, b, "development"); err != nil {
+		t.Fatal(err)
+	}
+	if c.User != "root" || c.Age != 10 {
+		t.Error(fmt.Sprintf("failed to load conf: %v", c))
+	}
+
+	c = &Conf{}
+	if err := LoadReader(c, strings.NewReader(string(b)), "production"); err != nil {
+		t.Fatal(err)
+	}
+	if c.User != "admin" || c.Age != 10 {
+		t.Error(fmt.Sprintf("failed to load conf: %v", c))
+	}
+}
+
+func TestLoadFS(t *testing.T) {
+	type User struct {
+		Name string
+		Age  int64
+	}
+
+	type Conf struct {
+		User User
+	}
+
+	// os.DirFS
+	{
+		c := &Conf{}
+		if err := LoadFS(c, os.DirFS("test"), "example2.toml", "production"); err != nil {
+			t.Fatal(err)
+		}
+		if c.User.Name != "user3" || c.User.Age != 20 {
+			t.Error(fmt.Sprintf("failed to load conf: %v", c))
+		}
+	}
+
+	// in-memory FS
+	{
+		fsys := fstest.MapFS{
+			"conf/app.toml": &fstest.MapFile{Data: []byte(`
+			[user]
+			name = "user1"
+			age = 10
+			[user.development]
+			name = "user2"
+			`)},
+		}
+		c := &Conf{}
+		if err := LoadFS(c, fsys, "conf/app.toml", "development"); err != nil {
+			t.Fatal(err)
+		}
+		if c.User.Name != "user2" || c.User.Age != 10 {
+			t.Error(fmt.Sprintf("failed to load conf: %v", c))
+		}
+		if err := LoadFS(c, fsys, "conf/missing.toml", "development"); err == nil {
+			t.Error("expected error for missing file")
+		}
+	}
+}
+
+func getValue(t reflect.Type, tree *toml.TomlTree, elem, env string) (reflect.Value, error) {
+	s := NewDecoder(WithEnvironment(env)).newDecodeState([]string{env}, "")
+	// the tests use the development and production environments
+	s.addEnvKeys("development", "production")
+	return s.getValue(t, s.documentLayers([]document{{tree: tree}}), elem)
+}
+
 func TestGetValue_string(t *testing.T) {
 	tree, e := toml.Load(`
 	user = "admin"
@@ -423,3 +509,670 @@ func TestSetStructFieldValue(t *testing.T) {
 		}
 	}
 }
+
+func TestLoad_optional(t *testing.T) {
+	type TLS struct {
+		Cert string `toml:"cert,required"`
+		Key  string `toml:",required"`
+	}
+
+	type Postgres struct {
+		User string `toml:",required"`
+		Host string
+		Port int
+	}
+
+	type Conf struct {
+		User     string `toml:"user,required"`
+		Password string `toml:"password,required"`
+		Timeout  int
+		Hosts    []string
+		Labels   map[string]string
+		Postgres Postgres
+		TLS      TLS
+	}
+
+	// missing optional keys are left at the zero value
+	{
+		c := &Conf{}
+		err := LoadBytes(c, []byte(`
+		user = "admin"
+		password = "12345"
+		[postgres]
+		user = "root"
+		[tls]
+		cert = "cert.pem"
+		key = "key.pem"
+		`), "production")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.User != "admin" || c.Timeout != 0 || c.Hosts != nil || c.Labels != nil || c.Postgres.User != "root" || c.Postgres.Host != "" {
+			t.Error(fmt.Sprintf("failed to load conf: %v", c))
+		}
+	}
+
+	// missing required keys are reported together
+	{
+		c := &Conf{}
+		err := LoadBytes(c, []byte(`
+		user = "admin"
+		[postgres]
+		host = "localhost"
+		`), "production")
+		errs, ok := err.(LoadErrors)
+		if !ok {
+			t.Fatalf("expected LoadErrors: %v", err)
+		}
+		var keys []string
+		for _, e := range errs {
+			if !errors.Is(e, ErrNotFound) {
+				t.Error(e)
+			}
+			keys = append(keys, e.Key)
+		}
+		if fmt.Sprint(keys) != "[password postgres.user tls.cert tls.key]" {
+			t.Errorf("unexpected errors: %v", err)
+		}
+		if c.User != "admin" || c.Postgres.Host != "localhost" {
+			t.Error(fmt.Sprintf("failed to load conf: %v", c))
+		}
+		t.Log(err)
+	}
+
+	// missing required keys are kept with the error that stops decoding
+	{
+		c := &struct {
+			A string `toml:"a,required"`
+			B int    `toml:"b"`
+		}{}
+		err := LoadBytes(c, []byte(`b = "x"`), "")
+		errs, ok := err.(LoadErrors)
+		if !ok || len(errs) != 2 || !errors.Is(errs[0], ErrNotFound) || errs[0].Key != "a" || !errors.Is(errs[1], ErrInvalidType) || errs[1].Key != "b" {
+			t.Errorf("unexpected errors: %v", err)
+		}
+	}
+}
+
+func TestLoad_default(t *testing.T) {
+	type Postgres struct {
+		Host string `default:"localhost"`
+		Port int    `toml:"port,default=5432"`
+	}
+
+	type Conf struct {
+		User     string    `toml:"user,default=admin"`
+		Port     string    `default:"8080"`
+		MaxConn  int       `toml:"max_conn" default:"10"`
+		Rate     float32   `default:"0.5"`
+		Debug    bool      `default:"true"`
+		Ports    []int     `toml:"ports,default=[80, 443]"`
+		Since    time.Time `default:"1980-01-01T00:00:00Z"`
+		Version  *string   `default:"12345"`
+		Enabled  *string   `default:"true"`
+		Postgres Postgres
+	}
+
+	b := []byte(`
+	max_conn = 20
+	[production]
+	user = "rouser"
+	`)
+
+	c := &Conf{}
+	if err := LoadBytes(c, b, "production"); err != nil {
+		t.Fatal(err)
+	}
+	since := time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+	if c.User != "rouser" || c.Port != "8080" || c.MaxConn != 20 || c.Rate != 0.5 || c.Debug != true || len(c.Ports) != 2 || c.Ports[1] != 443 || !c.Since.Equal(since) {
+		t.Error(fmt.Sprintf("failed to load conf: %+v", c))
+	}
+	if c.Version == nil || *c.Version != "12345" || c.Enabled == nil || *c.Enabled != "true" {
+		t.Error(fmt.Sprintf("failed to load string pointers: %+v", c))
+	}
+	if c.Postgres.Host != "localhost" || c.Postgres.Port != 5432 {
+		t.Error(fmt.Sprintf("failed to load struct: %+v", c))
+	}
+
+	c = &Conf{}
+	if err := LoadBytes(c, b, "development"); err != nil {
+		t.Fatal(err)
+	}
+	if c.User != "admin" {
+		t.Error(fmt.Sprintf("failed to load conf: %+v", c))
+	}
+
+	// invalid defaults
+	examples := []struct {
+		Conf interface{}
+		Err  error
+	}{
+		{Conf: &struct {
+			Num int8 `default:"1000"`
+		}{}, Err: ErrOverflow},
+		{Conf: &struct {
+			Num int `default:"ten"`
+		}{}, Err: ErrInvalidType},
+	}
+	for _, example := range examples {
+		err := LoadBytes(example.Conf, b, "production")
+		var le *LoadError
+		if !errors.As(err, &le) || !errors.Is(err, example.Err) || le.Key != "num" {
+			t.Errorf("unexpected error: %v", err)
+		}
+		t.Log(err)
+	}
+}
+
+func TestLoad_map(t *testing.T) {
+	type Server struct {
+		IP string
+		DC string
+	}
+
+	type Conf struct {
+		Servers map[string]Server
+		Labels  map[string]string
+		Limits  map[string]int
+	}
+
+	b := []byte(`
+	[servers.alpha]
+	ip = "10.0.0.1"
+	dc = "eqdc10"
+
+	[servers.beta]
+	ip = "10.0.0.2"
+	dc = "eqdc10"
+
+	[servers.production]
+	_delete = ["beta"]
+
+	[servers.production.gamma]
+	ip = "10.0.1.1"
+	dc = "eqdc20"
+
+	[servers.development.alpha]
+	ip = "127.0.0.1"
+	dc = "local"
+
+	[labels]
+	team = "infra"
+	tier = "backend"
+
+	[labels.production]
+	tier = "frontend"
+	owner = "ops"
+
+	[limits]
+	cpu = 1
+
+	[development]
+	[production]
+	`)
+
+	// development
+	{
+		c := &Conf{}
+		if err := LoadBytes(c, b, "development"); err != nil {
+			t.Fatal(err)
+		}
+		if len(c.Servers) != 2 || c.Servers["alpha"].IP != "127.0.0.1" || c.Servers["beta"].IP != "10.0.0.2" {
+			t.Error(fmt.Sprintf("failed to load map: %v", c.Servers))
+		}
+		if len(c.Labels) != 2 || c.Labels["tier"] != "backend" {
+			t.Error(fmt.Sprintf("failed to load map: %v", c.Labels))
+		}
+	}
+
+	// production
+	{
+		c := &Conf{}
+		if err := LoadBytes(c, b, "production"); err != nil {
+			t.Fatal(err)
+		}
+		if len(c.Servers) != 2 || c.Servers["alpha"].IP != "10.0.0.1" || c.Servers["gamma"].DC != "eqdc20" {
+			t.Error(fmt.Sprintf("failed to load map: %v", c.Servers))
+		}
+		if len(c.Labels) != 3 || c.Labels["tier"] != "frontend" || c.Labels["owner"] != "ops" {
+			t.Error(fmt.Sprintf("failed to load map: %v", c.Labels))
+		}
+	}
+
+	// unused tables that do not look like environment sections are not environments
+	{
+		c := &struct {
+			Hosts   map[string]string
+			Servers map[string]Server
+		}{}
+		if err := LoadBytes(c, []byte(`
+		[hosts]
+		legacy = "a"
+
+		[servers.legacy]
+		ip = "10.0.0.9"
+
+		[legacy]
+		note = "old"
+		`), ""); err != nil {
+			t.Fatal(err)
+		}
+		if c.Hosts["legacy"] != "a" || c.Servers["legacy"].IP != "10.0.0.9" {
+			t.Error(fmt.Sprintf("failed to load map: %v", c))
+		}
+	}
+
+	// quoted keys with dots
+	{
+		c := &struct {
+			Hosts   map[string]string
+			Servers map[string]Server
+		}{}
+		err := NewDecoder(WithSource(Bytes([]byte(`
+		[hosts]
+		"example.com" = "1.2.3.4"
+		localhost = "127.0.0.1"
+
+		[hosts.production]
+		"example.com" = "5.6.7.8"
+
+		[servers."db.example.com"]
+		ip = "10.0.0.5"
+
+		[production]
+		`))), WithEnvironment("production"), WithStrict()).Decode(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(c.Hosts) != 2 || c.Hosts["example.com"] != "5.6.7.8" || c.Hosts["localhost"] != "127.0.0.1" || c.Servers["db.example.com"].IP != "10.0.0.5" {
+			t.Error(fmt.Sprintf("failed to load quoted keys: %v", c))
+		}
+	}
+
+	// values keyed by environment names are entries
+	{
+		c := &struct {
+			DSN map[string]string
+		}{}
+		if err := LoadBytes(c, []byte(`
+		[dsn]
+		production = "p"
+		staging = "s"
+		`), "production"); err != nil {
+			t.Fatal(err)
+		}
+		if len(c.DSN) != 2 || c.DSN["production"] != "p" || c.DSN["staging"] != "s" {
+			t.Error(fmt.Sprintf("failed to load map: %v", c.DSN))
+		}
+	}
+
+	// invalid entries are not skipped
+	{
+		c := &Conf{}
+		err := LoadBytes(c, []byte(`
+		[limits]
+		cpu = 1
+		memory = "1GB"
+		`), "production")
+		var le *LoadError
+		if !errors.As(err, &le) || le.Field != "Limits[memory]" || le.Key != "limits.memory" {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+}
+
+func TestLoad_arraytable(t *testing.T) {
+	type Server struct {
+		Name   string
+		IP     string
+		Weight int
+	}
+
+	type Conf struct {
+		Servers []Server
+		Shards  []map[string]int
+	}
+
+	b := []byte(`
+	[[servers]]
+	name = "alpha"
+	ip = "10.0.0.1"
+	weight = 1
+
+	[servers.production]
+	ip = "10.1.0.1"
+
+	[servers.development]
+	ip = "127.0.0.1"
+	weight = 0
+
+	[[servers]]
+	name = "beta"
+	ip = "10.0.0.2"
+	weight = 1
+
+	[servers.production]
+	weight = 10
+
+	[[shards]]
+	size = 1
+
+	[shards.production]
+	size = 100
+	replicas = 3
+
+	[development]
+	[production]
+	`)
+
+	// production
+	{
+		c := &Conf{}
+		if err := LoadBytes(c, b, "production"); err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprintf("%+v", c.Servers) != "[{Name:alpha IP:10.1.0.1 Weight:1} {Name:beta IP:10.0.0.2 Weight:10}]" {
+			t.Error(fmt.Sprintf("failed to load array: %+v", c.Servers))
+		}
+		if len(c.Shards) != 1 || len(c.Shards[0]) != 2 || c.Shards[0]["size"] != 100 || c.Shards[0]["replicas"] != 3 {
+			t.Error(fmt.Sprintf("failed to load array: %+v", c.Shards))
+		}
+	}
+
+	// development
+	{
+		c := &Conf{}
+		if err := LoadBytes(c, b, "development"); err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprintf("%+v", c.Servers) != "[{Name:alpha IP:127.0.0.1 Weight:0} {Name:beta IP:10.0.0.2 Weight:1}]" {
+			t.Error(fmt.Sprintf("failed to load array: %+v", c.Servers))
+		}
+		if len(c.Shards) != 1 || len(c.Shards[0]) != 1 || c.Shards[0]["size"] != 1 {
+			t.Error(fmt.Sprintf("failed to load array: %+v", c.Shards))
+		}
+	}
+}
+
+func TestLoad_pointer(t *testing.T) {
+	type TLS struct {
+		Cert string
+		Key  string
+	}
+
+	type Server struct {
+		IP string
+	}
+
+	type Conf struct {
+		Port     *int
+		Host     *string
+		Timeout  *int `default:"30"`
+		TLS      *TLS
+		Ports    []*int
+		Servers  []*Server
+		Backends map[string]*Server
+		Tags     *[]string `merge:"append"`
+	}
+
+	b := []byte(`
+	port = 8080
+	ports = [80, 443]
+	tags = ["a"]
+
+	[[servers]]
+	ip = "10.0.0.1"
+
+	[backends.alpha]
+	ip = "10.0.0.2"
+
+	[production]
+	tags = ["b"]
+
+	[production.tls]
+	cert = "cert.pem"
+	key = "key.pem"
+	`)
+
+	// production
+	{
+		c := &Conf{}
+		if err := LoadBytes(c, b, "production"); err != nil {
+			t.Fatal(err)
+		}
+		if c.Port == nil || *c.Port != 8080 || c.Host != nil || c.Timeout == nil || *c.Timeout != 30 {
+			t.Error(fmt.Sprintf("failed to load pointer: %+v", c))
+		}
+		if c.TLS == nil || c.TLS.Cert != "cert.pem" {
+			t.Error(fmt.Sprintf("failed to load struct pointer: %+v", c.TLS))
+		}
+		if len(c.Ports) != 2 || *c.Ports[1] != 443 || len(c.Servers) != 1 || c.Servers[0].IP != "10.0.0.1" || c.Backends["alpha"].IP != "10.0.0.2" {
+			t.Error(fmt.Sprintf("failed to load pointer elements: %+v", c))
+		}
+		if c.Tags == nil || fmt.Sprint(*c.Tags) != "[a b]" {
+			t.Error(fmt.Sprintf("failed to load slice pointer: %+v", c.Tags))
+		}
+	}
+
+	// development
+	{
+		c := &Conf{}
+		if err := LoadBytes(c, b, "development"); err != nil {
+			t.Fatal(err)
+		}
+		if c.TLS != nil {
+			t.Error(fmt.Sprintf("failed to load struct pointer: %+v", c.TLS))
+		}
+	}
+
+	// required pointer
+	{
+		c := &struct {
+			TLS *TLS `toml:"tls,required"`
+		}{}
+		if err := LoadBytes(c, b, "development"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected not found: %v", err)
+		}
+	}
+}
+
+func TestLoad_nestedarray(t *testing.T) {
+	type Server struct {
+		IP string
+	}
+
+	type Conf struct {
+		Point   [3]int
+		Matrix  [][]int
+		Words   [][2]string
+		Labels  []map[string]string
+		Groups  [][]Server
+		Weights []*[]float64
+	}
+
+	b := []byte(`
+	point = [1, 2, 3]
+	matrix = [[1, 2], [3]]
+	words = [["a", "b"], ["c", "d"]]
+	labels = [{ env = "dev" }, { env = "prd" }]
+	groups = [[{ ip = "10.0.0.1" }, { ip = "10.0.0.2" }], [{ ip = "10.0.1.1" }]]
+	weights = [[0.5, 1.5]]
+
+	[production]
+	point = [4, 5, 6]
+	`)
+
+	c := &Conf{}
+	if err := LoadBytes(c, b, "production"); err != nil {
+		t.Fatal(err)
+	}
+	if c.Point != [3]int{4, 5, 6} || fmt.Sprint(c.Matrix) != "[[1 2] [3]]" || fmt.Sprint(c.Words) != "[[a b] [c d]]" {
+		t.Error(fmt.Sprintf("failed to load nested array: %+v", c))
+	}
+	if len(c.Labels) != 2 || c.Labels[1]["env"] != "prd" {
+		t.Error(fmt.Sprintf("failed to load array of maps: %+v", c.Labels))
+	}
+	if len(c.Groups) != 2 || len(c.Groups[0]) != 2 || c.Groups[0][1].IP != "10.0.0.2" || c.Groups[1][0].IP != "10.0.1.1" {
+		t.Error(fmt.Sprintf("failed to load nested array of tables: %+v", c.Groups))
+	}
+	if len(c.Weights) != 1 || fmt.Sprint(*c.Weights[0]) != "[0.5 1.5]" {
+		t.Error(fmt.Sprintf("failed to load nested array pointer: %+v", c.Weights))
+	}
+
+	// length
+	{
+		c := &Conf{}
+		err := LoadBytes(c, []byte(`point = [1, 2]`), "")
+		if !errors.Is(err, ErrInvalidType) || !strings.Contains(err.Error(), "2 elements do not fit [3]int") {
+			t.Errorf("expected length error: %v", err)
+		}
+	}
+
+	// element type
+	{
+		c := &Conf{}
+		err := LoadBytes(c, []byte(`matrix = [[1, 2], ["3"]]`), "")
+		var le *LoadError
+		if !errors.As(err, &le) || le.Field != "Matrix[1][0]" || le.Key != "matrix[1][0]" || le.Expected != "int" || le.Actual != "string" {
+			t.Errorf("expected element type error: %v", err)
+		}
+	}
+}
+
+type region string
+
+type zone struct {
+	Region string
+	Name   string
+}
+
+func (z *zone) UnmarshalText(b []byte) error {
+	i := strings.Index(string(b), "-")
+	if i < 0 {
+		return fmt.Errorf("zone %q has no region", b)
+	}
+	z.Region, z.Name = string(b[:i]), string(b[i+1:])
+	return nil
+}
+
+func TestLoad_mapkey(t *testing.T) {
+	type Shard struct {
+		Host string
+	}
+
+	type Conf struct {
+		Shards  map[int]Shard
+		Weights map[region]float64
+		Zones   map[zone]bool
+		Flags   map[bool]string
+	}
+
+	b := []byte(`
+	[shards.1]
+	host = "s1"
+
+	[shards.2]
+	host = "s2"
+
+	[weights]
+	eu = 0.5
+	us = 1.5
+
+	[zones]
+	eu-a = true
+
+	[flags]
+	true = "on"
+
+	[production.shards.2]
+	host = "s2.prod"
+	`)
+
+	c := &Conf{}
+	if err := LoadBytes(c, b, "production"); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Shards) != 1 || c.Shards[2].Host != "s2.prod" {
+		t.Error(fmt.Sprintf("failed to load int keys: %+v", c.Shards))
+	}
+	if c.Weights["us"] != 1.5 || !c.Zones[zone{"eu", "a"}] || c.Flags[true] != "on" {
+		t.Error(fmt.Sprintf("failed to load map keys: %+v", c))
+	}
+
+	if err := LoadBytes(c, b, "development"); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Shards) != 2 || c.Shards[1].Host != "s1" || c.Shards[2].Host != "s2" {
+		t.Error(fmt.Sprintf("failed to load int keys: %+v", c.Shards))
+	}
+
+	// invalid keys
+	for _, in := range []string{"[shards.one]\nhost = \"s1\"", "[shards.1000000000000000000000]\nhost = \"s1\"", "[zones]\neu = true"} {
+		c := &Conf{}
+		err := LoadBytes(c, []byte(in), "")
+		var le *LoadError
+		if !errors.As(err, &le) || !strings.HasPrefix(le.Field, "Shards[") && !strings.HasPrefix(le.Field, "Zones[") || le.Line == 0 {
+			t.Errorf("expected key error: %v", err)
+		}
+	}
+}
+
+type common struct {
+	Name    string
+	Verbose bool
+}
+
+func TestLoad_inline(t *testing.T) {
+	type Log struct {
+		Level string
+	}
+
+	type Postgres struct {
+		User string
+	}
+
+	type Conf struct {
+		common
+		*Postgres
+		Log      Log `toml:",inline"`
+		Port     int
+		Secret   string `toml:"-"`
+		Internal Log    `toml:"internal"`
+	}
+
+	b := []byte(`
+	name = "app"
+	user = "admin"
+	level = "info"
+	port = 8080
+	secret = "ignored"
+
+	[internal]
+	level = "debug"
+
+	[production]
+	verbose = true
+	level = "error"
+	`)
+
+	c := &Conf{}
+	if err := LoadBytes(c, b, "production"); err != nil {
+		t.Fatal(err)
+	}
+	if c.Name != "app" || !c.Verbose || c.Postgres == nil || c.User != "admin" || c.Log.Level != "error" || c.Port != 8080 {
+		t.Error(fmt.Sprintf("failed to load inline fields: %+v", c))
+	}
+	if c.Secret != "" || c.Internal.Level != "debug" {
+		t.Error(fmt.Sprintf("failed to load fields: %+v", c))
+	}
+
+	// strict mode knows the promoted fields and ignores skipped fields
+	{
+		c := &Conf{}
+		err := NewDecoder(WithSource(Bytes(b)), WithEnvironment("production"), WithStrict(), WithEnvironments("production")).Decode(c)
+		var le *LoadError
+		if !errors.As(err, &le) || le.Key != "secret" || !errors.Is(err, ErrUnknownKey) {
+			t.Errorf("expected unknown key secret: %v", err)
+		}
+	}
+}
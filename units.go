@@ -0,0 +1,105 @@
+package toml
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// durationValue returns v as a time.Duration, parsing strings like "1m30s"
+// and multiplying integers by the duration unit of the decoder.
+func (s *decodeState) durationValue(v interface{}) (reflect.Value, error) {
+	switch n := v.(type) {
+	case string:
+		d, err := time.ParseDuration(n)
+		if err != nil {
+			return nilValue, err
+		}
+		return reflect.ValueOf(d), nil
+	case int64:
+		unit := int64(s.durationUnit)
+		if unit > 1 && (n > math.MaxInt64/unit || n < math.MinInt64/unit) {
+			return nilValue, fmt.Errorf("%w: %v%s does not fit %s", ErrOverflow, n, s.durationUnit, durationType)
+		}
+		return reflect.ValueOf(time.Duration(n * unit)), nil
+	default:
+		return basicValue(durationType, v)
+	}
+}
+
+// A ByteSize is a number of bytes decoded from an integer or from a string with
+// a decimal unit (kB, MB, GB, TB, PB, EB) or a binary unit (KiB, MiB, GiB, TiB, PiB, EiB),
+// for example "10MB" or "512MiB". Units are not case sensitive.
+type ByteSize uint64
+
+var byteUnits = map[string]uint64{
+	"":    1,
+	"b":   1,
+	"kb":  1e3,
+	"mb":  1e6,
+	"gb":  1e9,
+	"tb":  1e12,
+	"pb":  1e15,
+	"eb":  1e18,
+	"kib": 1 << 10,
+	"mib": 1 << 20,
+	"gib": 1 << 30,
+	"tib": 1 << 40,
+	"pib": 1 << 50,
+	"eib": 1 << 60,
+}
+
+// UnmarshalTOML implements Unmarshaler, accepting non-negative integers and strings.
+func (b *ByteSize) UnmarshalTOML(v interface{}, env string) error {
+	switch v := v.(type) {
+	case int64:
+		rv, err := castValue(reflect.TypeOf(*b), reflect.ValueOf(v))
+		if err != nil {
+			return err
+		}
+		*b = rv.Interface().(ByteSize)
+		return nil
+	case string:
+		return b.UnmarshalText([]byte(v))
+	default:
+		return ErrInvalidType
+	}
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (b *ByteSize) UnmarshalText(text []byte) error {
+	str := strings.TrimSpace(string(text))
+	i := strings.IndexFunc(str, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i < 0 {
+		i = len(str)
+	}
+	num, unit := str[:i], strings.ToLower(strings.TrimSpace(str[i:]))
+	mul, ok := byteUnits[unit]
+	if !ok || num == "" {
+		return fmt.Errorf("invalid byte size %q", str)
+	}
+	if !strings.Contains(num, ".") {
+		n, err := strconv.ParseUint(num, 10, 64)
+		if err != nil || n > math.MaxUint64/mul {
+			return fmt.Errorf("%w: %s does not fit %T", ErrOverflow, str, *b)
+		}
+		*b = ByteSize(n * mul)
+		return nil
+	}
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return fmt.Errorf("invalid byte size %q", str)
+	}
+	if f*float64(mul) >= math.MaxUint64 {
+		return fmt.Errorf("%w: %s does not fit %T", ErrOverflow, str, *b)
+	}
+	*b = ByteSize(f * float64(mul))
+	return nil
+}
@@ -0,0 +1,95 @@
+package toml
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestLoad_duration(t *testing.T) {
+	type Conf struct {
+		Timeout  time.Duration
+		Interval time.Duration
+		Retry    time.Duration `default:"500ms"`
+		Backoff  []time.Duration
+	}
+
+	b := []byte(`
+	timeout = "1m30s"
+	interval = 5
+	backoff = ["1s", "2s"]
+
+	[production]
+	timeout = "10s"
+	`)
+
+	c := &Conf{}
+	if err := NewDecoder(WithSource(Bytes(b)), WithEnvironment("production"), WithDurationUnit(time.Second)).Decode(c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Timeout != 10*time.Second || c.Interval != 5*time.Second || c.Retry != 500*time.Millisecond || fmt.Sprint(c.Backoff) != "[1s 2s]" {
+		t.Error(fmt.Sprintf("failed to load duration: %+v", c))
+	}
+
+	// nanoseconds by default
+	{
+		c := &Conf{}
+		if err := LoadBytes(c, b, "development"); err != nil {
+			t.Fatal(err)
+		}
+		if c.Interval != 5 {
+			t.Error(fmt.Sprintf("failed to load duration: %+v", c))
+		}
+	}
+
+	// overflow
+	{
+		c := &Conf{}
+		err := NewDecoder(WithSource(Bytes([]byte(`interval = 9223372036854775807`))), WithDurationUnit(time.Hour)).Decode(c)
+		if !errors.Is(err, ErrOverflow) {
+			t.Errorf("expected overflow: %v", err)
+		}
+	}
+}
+
+func TestByteSize(t *testing.T) {
+	for in, want := range map[string]ByteSize{
+		"512":     512,
+		"10B":     10,
+		"10MB":    10e6,
+		"512MiB":  512 << 20,
+		"1.5 GiB": 3 << 29,
+		"2kb":     2000,
+	} {
+		var b ByteSize
+		if err := b.UnmarshalText([]byte(in)); err != nil || b != want {
+			t.Errorf("%q: got %d, %v, want %d", in, b, err, want)
+		}
+	}
+	for _, in := range []string{"", "MB", "10XB", "-1MB"} {
+		var b ByteSize
+		if err := b.UnmarshalText([]byte(in)); err == nil {
+			t.Errorf("%q: expected error", in)
+		}
+	}
+	var b ByteSize
+	if err := b.UnmarshalText([]byte("20EiB")); !errors.Is(err, ErrOverflow) {
+		t.Errorf("expected overflow: %v", err)
+	}
+
+	type Conf struct {
+		Cache ByteSize
+		Limit ByteSize
+	}
+	c := &Conf{}
+	if err := LoadBytes(c, []byte("cache = \"64MiB\"\nlimit = 1024"), ""); err != nil {
+		t.Fatal(err)
+	}
+	if c.Cache != 64<<20 || c.Limit != 1024 {
+		t.Error(fmt.Sprintf("failed to load byte size: %+v", c))
+	}
+	if err := LoadBytes(c, []byte("limit = -1"), ""); !errors.Is(err, ErrOverflow) {
+		t.Errorf("expected overflow: %v", err)
+	}
+}
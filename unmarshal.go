@@ -0,0 +1,109 @@
+package toml
+
+import (
+	"encoding"
+	"github.com/pelletier/go-toml"
+	"reflect"
+)
+
+// An Unmarshaler decodes its own value from the TOML value of its key.
+// Tables are given as map[string]interface{} and arrays of tables as
+// []map[string]interface{}. env is the environment being loaded.
+type Unmarshaler interface {
+	UnmarshalTOML(v interface{}, env string) error
+}
+
+var (
+	unmarshalerType       = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+	textUnmarshalerType   = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	binaryUnmarshalerType = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+)
+
+// isUnmarshaler reports whether a pointer to t decodes its own value.
+func isUnmarshaler(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr || t.Kind() == reflect.Interface {
+		return false
+	}
+	pt := reflect.PtrTo(t)
+	return pt.Implements(unmarshalerType) || pt.Implements(textUnmarshalerType) || pt.Implements(binaryUnmarshalerType)
+}
+
+// convertValue returns the raw TOML value v as a value of type t.
+// Types implementing Unmarshaler decode any value, and types implementing
+// encoding.TextUnmarshaler or encoding.BinaryUnmarshaler decode strings only,
+// so their own validation is never skipped; other values must already be of type t,
+// such as the datetimes of time.Time.
+func (s *decodeState) convertValue(t reflect.Type, v interface{}) (reflect.Value, error) {
+	if t.Kind() == reflect.Ptr {
+		ev, err := s.convertValue(t.Elem(), v)
+		if err != nil {
+			return nilValue, err
+		}
+		rv := reflect.New(t.Elem())
+		rv.Elem().Set(ev)
+		return rv, nil
+	}
+	if t == durationType {
+		return s.durationValue(v)
+	}
+	if !isUnmarshaler(t) {
+		return basicValue(t, v)
+	}
+	rv := reflect.New(t)
+	var err error
+	str, isString := v.(string)
+	switch u := rv.Interface().(type) {
+	case Unmarshaler:
+		err = u.UnmarshalTOML(plainValue(v), s.env())
+	case encoding.TextUnmarshaler, encoding.BinaryUnmarshaler:
+		if !isString {
+			if reflect.TypeOf(v) == t {
+				return reflect.ValueOf(v), nil
+			}
+			return nilValue, ErrInvalidType
+		}
+		if tu, ok := u.(encoding.TextUnmarshaler); ok {
+			err = tu.UnmarshalText([]byte(str))
+		} else {
+			err = u.(encoding.BinaryUnmarshaler).UnmarshalBinary([]byte(str))
+		}
+	}
+	if err != nil {
+		return nilValue, err
+	}
+	return rv.Elem(), nil
+}
+
+// env returns the most specific environment being loaded.
+func (s *decodeState) env() string {
+	if len(s.envs) == 0 {
+		return ""
+	}
+	return s.envs[0]
+}
+
+// plainValue returns v with its tables converted to maps.
+func plainValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case *toml.TomlTree:
+		m := map[string]interface{}{}
+		for _, k := range v.Keys() {
+			m[k] = plainValue(v.GetPath([]string{k}))
+		}
+		return m
+	case []*toml.TomlTree:
+		a := make([]map[string]interface{}, len(v))
+		for i, t := range v {
+			a[i] = plainValue(t).(map[string]interface{})
+		}
+		return a
+	case []interface{}:
+		a := make([]interface{}, len(v))
+		for i, e := range v {
+			a[i] = plainValue(e)
+		}
+		return a
+	default:
+		return v
+	}
+}
@@ -0,0 +1,136 @@
+package toml
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+type logLevel int
+
+func (l *logLevel) UnmarshalText(b []byte) error {
+	switch string(b) {
+	case "debug":
+		*l = 0
+	case "info":
+		*l = 1
+	case "error":
+		*l = 2
+	default:
+		return fmt.Errorf("unknown log level %q", b)
+	}
+	return nil
+}
+
+type endpoint struct {
+	Host    string
+	Port    int64
+	Env     string
+	Headers map[string]interface{}
+}
+
+func (e *endpoint) UnmarshalTOML(v interface{}, env string) error {
+	switch v := v.(type) {
+	case string:
+		e.Host = v
+	case map[string]interface{}:
+		e.Host, _ = v["host"].(string)
+		e.Port, _ = v["port"].(int64)
+		e.Headers, _ = v["headers"].(map[string]interface{})
+	default:
+		return fmt.Errorf("unexpected %T", v)
+	}
+	e.Env = env
+	return nil
+}
+
+func TestLoad_unmarshaler(t *testing.T) {
+	type Conf struct {
+		Level     logLevel
+		Levels    []logLevel
+		IP        net.IP
+		URL       *url.URL
+		Pattern   *regexp.Regexp
+		Primary   endpoint
+		Replicas  []endpoint
+		Endpoints map[string]*endpoint
+	}
+
+	b := []byte(`
+	level = "debug"
+	levels = ["info", "error"]
+	ip = "127.0.0.1"
+	url = "https://example.com/path"
+	pattern = "^a+$"
+	replicas = ["r1", "r2"]
+
+	[primary]
+	host = "db"
+	port = 5432
+
+	[endpoints]
+	alpha = "a.example.com"
+
+	[production]
+	level = "error"
+
+	[production.primary]
+	host = "db.prod"
+	port = 5433
+	`)
+
+	c := &Conf{}
+	if err := LoadBytes(c, b, "production"); err != nil {
+		t.Fatal(err)
+	}
+	if c.Level != 2 || fmt.Sprint(c.Levels) != "[1 2]" {
+		t.Error(fmt.Sprintf("failed to load text unmarshaler: %+v", c))
+	}
+	if c.IP.String() != "127.0.0.1" || c.URL.Host != "example.com" || !c.Pattern.MatchString("aaa") {
+		t.Error(fmt.Sprintf("failed to load text unmarshaler: %+v", c))
+	}
+	if c.Primary.Host != "db.prod" || c.Primary.Port != 5433 || c.Primary.Env != "production" {
+		t.Error(fmt.Sprintf("failed to load unmarshaler: %+v", c.Primary))
+	}
+	if len(c.Replicas) != 2 || c.Replicas[1].Host != "r2" || c.Endpoints["alpha"].Host != "a.example.com" {
+		t.Error(fmt.Sprintf("failed to load unmarshaler elements: %+v", c))
+	}
+
+	// quoted keys of tables are kept whole
+	{
+		c := &Conf{}
+		b := []byte(`
+		[primary.headers]
+		"x.request.id" = "abc"
+		`)
+		if err := LoadBytes(c, b, "production"); err != nil {
+			t.Fatal(err)
+		}
+		if c.Primary.Headers["x.request.id"] != "abc" {
+			t.Error(fmt.Sprintf("failed to load quoted keys: %+v", c.Primary.Headers))
+		}
+	}
+
+	// a text unmarshaler only decodes strings
+	{
+		c := &Conf{}
+		err := LoadBytes(c, []byte(`level = 3`), "production")
+		var le *LoadError
+		if !errors.As(err, &le) || !errors.Is(err, ErrInvalidType) || le.Expected != "toml.logLevel" || le.Actual != "integer" {
+			t.Errorf("expected invalid type: %v", err)
+		}
+	}
+
+	// error
+	{
+		c := &Conf{}
+		err := LoadBytes(c, []byte(`level = "trace"`), "production")
+		if err == nil || !strings.Contains(err.Error(), `unknown log level "trace"`) {
+			t.Errorf("expected unmarshal error: %v", err)
+		}
+	}
+}